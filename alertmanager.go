@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// WebhookMessage is the payload posted by alertmanager's webhook receiver (version 4), or by grafana
+// unified alerting which sends the same message with a few fields more
+type WebhookMessage struct {
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	TruncatedAlerts   int               `json:"truncatedAlerts"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	OrgID             int64             `json:"orgId"`   // grafana only
+	Title             string            `json:"title"`   // grafana only
+	Message           string            `json:"message"` // grafana only
+	Alerts            []GrafanaAlert    `json:"alerts"`
+}
+
+// decodeAlerts parses an incoming request body as it is read, which is either a bare array of alerts
+// as posted by prometheus, an alertmanager webhook message or a grafana webhook message.
+// The returned status is "firing" or "resolved" for webhook messages, empty otherwise.
+func decodeAlerts(r io.Reader) ([]Alert, string, error) {
+	br := bufio.NewReader(r)
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, "", err
+		}
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			continue
+		}
+		br.UnreadByte()
+		if c == '{' {
+			return decodeWebhookMessage(br)
+		}
+		break
+	}
+	var alerts []Alert
+	if err := decodeJSON(br, &alerts); err != nil {
+		return nil, "", err
+	}
+	return alerts, "", nil
+}
+
+// decodeWebhookMessage decodes an alertmanager or grafana webhook message, grafana alerts being
+// alertmanager alerts with a few fields more
+func decodeWebhookMessage(r io.Reader) ([]Alert, string, error) {
+	var m WebhookMessage
+	if err := decodeJSON(r, &m); err != nil {
+		return nil, "", err
+	}
+	alerts := make([]Alert, 0, len(m.Alerts))
+	for i := range m.Alerts {
+		if m.Alerts[i].Status == "" {
+			m.Alerts[i].Status = m.Status // senders without a status per alert
+		}
+		alerts = append(alerts, m.Alerts[i].toAlert())
+	}
+	return alerts, m.Status, nil
+}
+
+// decodeJSON decodes the single json value read from r into v
+func decodeJSON(r io.Reader, v interface{}) error {
+	decoder := json.NewDecoder(r)
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		if err == nil {
+			err = errors.New("invalid character after top-level value")
+		}
+		return err
+	}
+	return nil
+}
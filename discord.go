@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// DiscordNotifier posts payloads as embeds to discord webhooks
+type DiscordNotifier struct {
+	webhookClient
+	Webhook  string            // used for channels without their own webhook
+	Channels map[string]string // channel name to webhook
+}
+
+type DiscordMessage struct {
+	Content string         `json:"content,omitempty"`
+	Embeds  []DiscordEmbed `json:"embeds,omitempty"`
+}
+
+type DiscordEmbed struct {
+	Title       string         `json:"title,omitempty"`
+	URL         string         `json:"url,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Color       int64          `json:"color,omitempty"`
+	Fields      []DiscordField `json:"fields,omitempty"`
+	Footer      *DiscordFooter `json:"footer,omitempty"`
+}
+
+type DiscordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+type DiscordFooter struct {
+	Text string `json:"text"`
+}
+
+func (n *DiscordNotifier) NotifyAlert(ctx context.Context, a *Alert, payloads []Payload) error {
+	return notifyWebhooks(ctx, n, payloads)
+}
+
+// webhook returns the webhook of channel, empty if it isn't sent to discord
+func (n *DiscordNotifier) webhook(channel string) string {
+	if webhook, found := n.Channels[strings.TrimLeft(channel, "#")]; found {
+		return webhook
+	}
+	return n.Webhook
+}
+
+func (n *DiscordNotifier) Notify(ctx context.Context, p *Payload) error {
+	webhook := n.webhook(p.Channel)
+	if webhook == "" {
+		slog.Debug("no discord webhook, alert is not sent to discord", "channel", p.Channel)
+		return nil
+	}
+	data, err := json.Marshal(toDiscordMessage(p))
+	if err != nil {
+		slog.Error("failed to marshal payload, alert would not sent", "payload", p, "err", err)
+		return err
+	}
+	return n.send(ctx, webhook, p.Channel, data)
+}
+
+// discordMaxEmbeds is the max number of embeds discord accepts in one message
+const discordMaxEmbeds = 10
+
+// toDiscordMessage converts every attachment of a slack payload to an embed
+func toDiscordMessage(p *Payload) *DiscordMessage {
+	m := &DiscordMessage{Content: plainText(p)}
+	for i, a := range p.Attachments {
+		if i == discordMaxEmbeds {
+			slog.Warn("too many alerts for one discord message, dropping the rest", "channel", p.Channel, "alerts", len(p.Attachments))
+			break
+		}
+		embed := DiscordEmbed{Title: a.Title, URL: a.TitleLink, Description: a.Text}
+		color := strings.TrimPrefix(a.Color, "#")
+		if c, found := teamsColors[a.Color]; found {
+			color = c
+		}
+		embed.Color, _ = strconv.ParseInt(color, 16, 64)
+		for _, f := range a.Fields {
+			embed.Fields = append(embed.Fields, DiscordField{Name: f.Title, Value: f.Value, Inline: f.Short})
+		}
+		if a.Footer != "" {
+			embed.Footer = &DiscordFooter{Text: a.Footer}
+		}
+		m.Embeds = append(m.Embeds, embed)
+	}
+	return m
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDiscordPostsEachWebhookOnce(t *testing.T) {
+	server, posts := webhookServer(t)
+	n := &DiscordNotifier{
+		webhookClient: webhookClient{Client: server.Client()},
+		Webhook:       server.URL + "/default",
+		Channels:      map[string]string{"ops": server.URL + "/ops"},
+	}
+	a := testAlert("ServiceDown")
+	var payloads []Payload
+	for _, channel := range []string{"#ops", "#dev", "@oncall"} {
+		payloads = append(payloads, Payload{Channel: channel, Attachments: []Attachment{{Title: "ServiceDown"}}, Alerts: []*Alert{&a}})
+	}
+	if err := n.NotifyAlert(context.Background(), &a, payloads); err != nil {
+		t.Fatal(err)
+	}
+	if posts["/ops"] != 1 || posts["/default"] != 1 || len(posts) != 2 {
+		t.Errorf("posts = %v, want one to /ops and one to /default", posts)
+	}
+}
+
+func TestDiscordContentUnescaped(t *testing.T) {
+	srv, _ := newTestServer(t, "-external_url=http://molert:8080/a&b", "-silence_duration=3600")
+	a := testAlert("ServiceDown")
+	a.GeneratorURL = "http://prometheus:9090/graph?g0.expr=up<1&g0.tab=1"
+	s, _ := json.Marshal(Silence{URL: a.GeneratorURL, Duration: 3600})
+	tests := []struct {
+		name string
+		p    Payload
+		want string
+	}{
+		{"silence command", srv.toPayloads(&a, time.Time{})[0], "`curl -XPOST 'http://molert:8080/a&b/silence' -H 'Content-Type: application/json' -d " + shellQuote(string(s)) + "`"},
+		{"watchdog", Payload{Text: "molert has not received any alert for 600 seconds"}, "molert has not received any alert for 600 seconds"},
+	}
+	for _, tt := range tests {
+		if got := toDiscordMessage(&tt.p).Content; got != tt.want {
+			t.Errorf("%s: content = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
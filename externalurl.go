@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// knownExternalURL returns external_url, or when it is unset the url derived from requests,
+// empty until one is received
+func (srv *Server) knownExternalURL() string {
+	if srv.config().ExternalURL != "" {
+		return srv.config().ExternalURL
+	}
+	derived, _ := srv.derivedURL.Load().(string)
+	return derived
+}
+
+// externalURL returns knownExternalURL, falling back to listen_addr
+func (srv *Server) externalURL() string {
+	if u := srv.knownExternalURL(); u != "" {
+		return u
+	}
+	scheme := "http"
+	if srv.config().TLSCert != "" {
+		scheme = "https"
+	}
+	host, port, err := net.SplitHostPort(srv.config().ListenAddr)
+	if err != nil {
+		return scheme + "://" + srv.config().ListenAddr
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		if hostname, err := os.Hostname(); err == nil {
+			host = hostname
+		}
+	}
+	return scheme + "://" + net.JoinHostPort(host, port)
+}
+
+// deriveExternalURL remembers the url of the first authenticated request to a molert endpoint, or of
+// the first request from a trusted proxy when auth is disabled, when external_url is unset. Requests to unknown paths, which the "/" pattern serves, are ignored.
+func (srv *Server) deriveExternalURL(r *http.Request) {
+	if srv.config().ExternalURL != "" || srv.derivedURL.Load() != nil {
+		return
+	}
+	if _, pattern := srv.mux.Handler(r); pattern != r.URL.Path {
+		return
+	}
+	u := srv.requestURL(r)
+	if srv.derivedURL.CompareAndSwap(nil, u) {
+		slog.Info("external_url not set, using the url of the first trusted request", "url", u)
+	}
+}
+
+// requestURL returns the scheme and host the sender of r used, taken from
+// X-Forwarded-Proto and X-Forwarded-Host when r comes from a trusted proxy
+func (srv *Server) requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host := r.Host
+	if srv.trustedProxy(r) {
+		if proto := firstValue(r.Header.Get("X-Forwarded-Proto")); proto != "" {
+			scheme = proto
+		}
+		if forwarded := firstValue(r.Header.Get("X-Forwarded-Host")); forwarded != "" {
+			host = forwarded
+		}
+	}
+	return scheme + "://" + host
+}
+
+// trustedProxy reports whether r comes from an address of trusted_proxies
+func (srv *Server) trustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	for _, n := range srv.config().trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies parses comma separated addresses and cidrs, eg. "10.0.0.1,192.168.0.0/16"
+func parseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q, expected an address or a cidr", v)
+			}
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 8 * net.IPv6len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %s", v, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// firstValue returns the first of comma separated header values, added by each proxy on the way
+func firstValue(s string) string {
+	if i := strings.Index(s, ","); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestDeriveExternalURL(t *testing.T) {
+	auth := []string{"-auth_user=molert", "-auth_pass=secret", "-trusted_proxies=10.0.0.1,192.168.0.0/16"}
+	tests := []struct {
+		name    string
+		args    []string
+		path    string
+		auth    bool
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"no auth configured", nil, "/config", false, "192.0.2.1:1234", nil, ""},
+		{"no auth untrusted proxy", []string{"-trusted_proxies=10.0.0.1"}, "/config", false, "192.0.2.1:1234",
+			map[string]string{"X-Forwarded-Host": "evil.example"}, ""},
+		{"no auth trusted proxy", []string{"-trusted_proxies=10.0.0.1"}, "/config", false, "10.0.0.1:1234",
+			map[string]string{"X-Forwarded-Host": "alerts.example", "X-Forwarded-Proto": "https"}, "https://alerts.example"},
+		{"no auth trusted proxy unknown path", []string{"-trusted_proxies=10.0.0.1"}, "/nope", false, "10.0.0.1:1234",
+			map[string]string{"X-Forwarded-Host": "alerts.example"}, ""},
+		{"unauthenticated", auth, "/config", false, "192.0.2.1:1234", nil, ""},
+		{"unknown path", auth, "/nope", true, "192.0.2.1:1234", nil, ""},
+		{"known path", auth, "/config", true, "192.0.2.1:1234", nil, "http://molert.example"},
+		{"index", auth, "/", true, "192.0.2.1:1234", nil, "http://molert.example"},
+		{"untrusted proxy", auth, "/config", true, "192.0.2.1:1234",
+			map[string]string{"X-Forwarded-Host": "evil.example", "X-Forwarded-Proto": "https"}, "http://molert.example"},
+		{"trusted proxy", auth, "/config", true, "10.0.0.1:1234",
+			map[string]string{"X-Forwarded-Host": "alerts.example, molert.example", "X-Forwarded-Proto": "https"}, "https://alerts.example"},
+		{"trusted proxy network", auth, "/config", true, "192.168.1.2:1234",
+			map[string]string{"X-Forwarded-Host": "alerts.example"}, "http://alerts.example"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := newTestServer(t, tt.args...)
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			r.Host = "molert.example"
+			r.RemoteAddr = tt.remote
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			if tt.auth {
+				r.SetBasicAuth("molert", "secret")
+			}
+			srv.mux.ServeHTTP(httptest.NewRecorder(), r)
+			if got := srv.knownExternalURL(); got != tt.want {
+				t.Errorf("derived url = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"10.0.0.1", 1, false},
+		{"10.0.0.1, 192.168.0.0/16, ::1", 3, false},
+		{"proxy.example", 0, true},
+		{"10.0.0.0/33", 0, true},
+	}
+	for _, tt := range tests {
+		nets, err := parseTrustedProxies(tt.s)
+		if (err != nil) != tt.wantErr || len(nets) != tt.want {
+			t.Errorf("parseTrustedProxies(%q) = %v, %v, want %d networks, error %v", tt.s, nets, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestOpenInMolertButton(t *testing.T) {
+	srv, _ := newTestServer(t)
+	a := testAlert("ServiceDown")
+	link := func() string {
+		for _, action := range srv.toPayloads(&a, time.Time{})[0].Attachments[0].Actions {
+			if action.Text == "Open in molert" {
+				return action.URL
+			}
+		}
+		return ""
+	}
+	if got := link(); got != "" {
+		t.Errorf("button linking to %q without an external url", got)
+	}
+	srv.derivedURL.Store("https://alerts.example")
+	if got, want := link(), "https://alerts.example/?url="+url.QueryEscape(a.GeneratorURL); got != want {
+		t.Errorf("button links to %q, want %q", got, want)
+	}
+}
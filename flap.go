@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// resolvedKey returns the redis key recording that the alert of url resolved less than flap_suppress ago
+func (srv *Server) resolvedKey(url string) string {
+	return srv.urlKey("resolved:", url)
+}
+
+// markResolved records that the alert was just resolved, so that firing again within flap_suppress is a flap
+func (srv *Server) markResolved(a *Alert) {
+	seconds := int64(srv.config().FlapSuppress / time.Second)
+	if seconds <= 0 {
+		return
+	}
+	resp := srv.redis.Cmd("SET", srv.resolvedKey(a.GeneratorURL), time.Now().Unix(), "EX", seconds)
+	if resp.Err != nil {
+		slog.Warn("failed to record resolved time", "url", a.GeneratorURL, "command", "SET", "err", resp.Err)
+	}
+}
+
+// markRefired records when a new firing alert was resolved less than flap_suppress ago,
+// it is then held back by flapping until it has been firing for flap_suppress
+func (srv *Server) markRefired(a *Alert) {
+	if srv.config().FlapSuppress <= 0 {
+		return
+	}
+	if n, err := srv.redis.Cmd("EXISTS", srv.resolvedKey(a.GeneratorURL)).Int(); err != nil || n == 0 {
+		return
+	}
+	resp := srv.redis.Cmd("HSET", srv.alertKey(a.GeneratorURL), "refired_at", time.Now().Unix())
+	if resp.Err != nil {
+		slog.Warn("failed to record refired time", "url", a.GeneratorURL, "command", "HSET", "err", resp.Err)
+		return
+	}
+	slog.Debug("alert fired again shortly after it resolved, holding it back", "url", a.GeneratorURL, "for", srv.config().FlapSuppress)
+}
+
+// flapping reports whether the alert fired again shortly after it resolved and hasn't been
+// firing for flap_suppress since, an alert already sent is never held back. Like any alert
+// never sent, a flap resolving meanwhile gets no resolved message
+func (srv *Server) flapping(as *AlertStatus, now time.Time) bool {
+	if as.RefiredAt == 0 || as.LastNotified != 0 {
+		return false
+	}
+	return now.Sub(time.Unix(as.RefiredAt, 0)) < srv.config().FlapSuppress
+}
@@ -0,0 +1,45 @@
+package main
+
+import "time"
+
+// GrafanaAlert is an alert of a webhook message, grafana adds valueString, dashboardURL and panelURL
+// to the fields alertmanager sends
+type GrafanaAlert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+	ValueString  string            `json:"valueString"`
+	DashboardURL string            `json:"dashboardURL"`
+	PanelURL     string            `json:"panelURL"`
+}
+
+// toAlert converts a webhook alert, keeping the grafana value and dashboard as annotations
+func (ga *GrafanaAlert) toAlert() Alert {
+	a := Alert{
+		Labels:       ga.Labels,
+		Annotations:  ga.Annotations,
+		StartsAt:     ga.StartsAt,
+		EndsAt:       ga.EndsAt,
+		GeneratorURL: ga.GeneratorURL,
+		Fingerprint:  ga.Fingerprint,
+		Status:       ga.Status,
+	}
+	if a.Annotations == nil {
+		a.Annotations = map[string]string{}
+	}
+	if _, found := a.Annotations["value"]; !found && ga.ValueString != "" {
+		a.Annotations["value"] = ga.ValueString
+	}
+	if _, found := a.Annotations["dashboard"]; !found && ga.DashboardURL != "" {
+		a.Annotations["dashboard"] = ga.DashboardURL
+	}
+	// make sure a resolved alert without endsAt is still considered ended
+	if ga.Status == "resolved" && a.EndsAt.IsZero() {
+		a.EndsAt = time.Now()
+	}
+	return a
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// HistoryEntry is a state change of an alert, kept in a capped list per alert with history_length
+type HistoryEntry struct {
+	Time        time.Time `json:"time"`   // when molert received it
+	Status      string    `json:"status"` // firing or resolved
+	StartsAt    time.Time `json:"startsAt"`
+	EndsAt      time.Time `json:"endsAt"`
+	Fingerprint string    `json:"fingerprint"`
+}
+
+// historyKey returns the redis key of the history list of url
+func (srv *Server) historyKey(url string) string {
+	return srv.urlKey("history:", url)
+}
+
+// record appends the received alert to its history when its status or startsAt changed
+// since the last entry, a failure is only logged since history is best effort
+func (srv *Server) record(a *Alert) {
+	if srv.config().HistoryLength <= 0 {
+		return
+	}
+	entry := HistoryEntry{Time: time.Now(), Status: "firing", StartsAt: a.StartsAt, EndsAt: a.EndsAt, Fingerprint: a.fingerprint()}
+	if a.resolved() {
+		entry.Status = "resolved"
+	}
+	key := srv.historyKey(a.GeneratorURL)
+	if last, err := srv.redis.Cmd("LINDEX", key, 0).Bytes(); err == nil {
+		var prev HistoryEntry
+		if json.Unmarshal(last, &prev) == nil && prev.Status == entry.Status && prev.StartsAt.Equal(entry.StartsAt) {
+			return
+		}
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		slog.Warn("failed to marshal history entry", "url", a.GeneratorURL, "err", err)
+		return
+	}
+	resps := srv.redis.Pipe([]redisCmd{
+		{"LPUSH", []interface{}{key, data}},
+		{"LTRIM", []interface{}{key, 0, srv.config().HistoryLength - 1}},
+		{"EXPIRE", []interface{}{key, srv.config().HistoryExpiration}},
+	})
+	for _, resp := range resps {
+		if resp.Err != nil {
+			slog.Warn("failed to record alert history", "url", a.GeneratorURL, "err", resp.Err)
+			return
+		}
+	}
+	slog.Debug("alert history recorded", "url", a.GeneratorURL, "status", entry.Status)
+}
+
+// historyHandler lists the recorded state changes of the alert of the url query parameter, newest first
+func (srv *Server) historyHandler(w http.ResponseWriter, r *http.Request) {
+	url := r.URL.Query().Get("url")
+	if url == "" {
+		writeError(w, http.StatusBadRequest, errors.New("url is required"))
+		return
+	}
+	values, err := srv.redis.Cmd("LRANGE", srv.historyKey(url), 0, -1).ListBytes()
+	if err != nil {
+		slog.Error("failed to get history", "url", url, "command", "LRANGE", "err", err)
+		err = fmt.Errorf("failed to get history of %s: %w", url, err)
+		writeError(w, redisErrorStatus(err), err)
+		return
+	}
+	history := []HistoryEntry{}
+	for _, value := range values {
+		var entry HistoryEntry
+		if err := json.Unmarshal(value, &entry); err != nil {
+			slog.Warn("failed to unmarshal history entry", "url", url, "entry", string(value), "err", err)
+			continue
+		}
+		history = append(history, entry)
+	}
+	writeJSON(w, http.StatusOK, history)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+)
+
+// InhibitRule holds back the alerts matching TargetMatchers while a firing alert matches SourceMatchers
+// and has the same values of the Equal labels, like the inhibit_rules of alertmanager
+type InhibitRule struct {
+	SourceMatchers Matchers `json:"source_matchers"`
+	TargetMatchers Matchers `json:"target_matchers"`
+	Equal          []string `json:"equal,omitempty"`
+}
+
+// loadInhibitRules reads a json file of inhibit rules
+func loadInhibitRules(file string) ([]InhibitRule, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inhibit rules: %s", err)
+	}
+	var rules []InhibitRule
+	if err = json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse inhibit rules %s: %s", file, err)
+	}
+	for i, rule := range rules {
+		if len(rule.SourceMatchers) == 0 || len(rule.TargetMatchers) == 0 {
+			return nil, fmt.Errorf("inhibit rule %d in %s needs both source_matchers and target_matchers", i, file)
+		}
+	}
+	return rules, nil
+}
+
+// inhibited returns the alerts held back by a firing source alert of an inhibit rule.
+// An alert matching both the source and target of a rule doesn't inhibit itself.
+func (srv *Server) inhibited(alerts []*AlertStatus) map[*AlertStatus]bool {
+	inhibited := map[*AlertStatus]bool{}
+	for _, rule := range srv.config().inhibitRules {
+		var sources []*AlertStatus
+		for _, as := range alerts {
+			if !as.Alert.resolved() && as.Alert.matches(rule.SourceMatchers) {
+				sources = append(sources, as)
+			}
+		}
+		if len(sources) == 0 {
+			continue
+		}
+		for _, target := range alerts {
+			if inhibited[target] || !target.Alert.matches(rule.TargetMatchers) {
+				continue
+			}
+			for _, source := range sources {
+				if source != target && rule.equal(&source.Alert, &target.Alert) {
+					inhibited[target] = true
+					slog.Debug("alert inhibited", "url", target.Alert.GeneratorURL, "by", source.Alert.GeneratorURL)
+					break
+				}
+			}
+		}
+	}
+	return inhibited
+}
+
+// equal reports whether both alerts have the same values of the Equal labels, a missing label counts as empty
+func (rule *InhibitRule) equal(source, target *Alert) bool {
+	for _, name := range rule.Equal {
+		if source.Labels[name] != target.Labels[name] {
+			return false
+		}
+	}
+	return true
+}
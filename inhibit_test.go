@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestInhibited(t *testing.T) {
+	rules := filepath.Join(t.TempDir(), "inhibit_rules.json")
+	err := os.WriteFile(rules, []byte(`[
+		{"source_matchers": {"alertname": "HostDown"}, "target_matchers": {"alertname": "ServiceDown"}, "equal": ["instance"]},
+		{"source_matchers": [{"name": "severity", "value": "critical"}], "target_matchers": [{"name": "severity", "value": "warning|info", "isRegex": true}], "equal": ["cluster"]}
+	]`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := loadConfig([]string{"-inhibit_rules=" + rules})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{}
+	srv.setConfig(config)
+	hostDown := testAlert("HostDown", "severity", "critical", "cluster", "eu")
+	hostUp := testAlert("HostDown", "severity", "critical")
+	hostUp.Status = "resolved"
+	tests := []struct {
+		name      string
+		alerts    []Alert
+		inhibited []string // alertname@instance
+	}{
+		{"host down inhibits service", []Alert{hostDown, testAlert("ServiceDown", "severity", "info")}, []string{"ServiceDown@host1"}},
+		{"other host", []Alert{hostDown, testAlert("ServiceDown", "instance", "host2", "severity", "info")}, nil},
+		{"resolved source", []Alert{hostUp, testAlert("ServiceDown", "severity", "info")}, nil},
+		{"no source", []Alert{testAlert("ServiceDown")}, nil},
+		{"missing equal label is empty", []Alert{testAlert("HighLoad", "severity", "critical"), testAlert("DiskFull", "instance", "host2")}, []string{"DiskFull@host2"}},
+		{"equal label differs", []Alert{hostDown, testAlert("DiskFull", "cluster", "us")}, nil},
+	}
+	for _, tt := range tests {
+		var alerts []*AlertStatus
+		for _, a := range tt.alerts {
+			alerts = append(alerts, &AlertStatus{Alert: a})
+		}
+		var inhibited []string
+		for as := range srv.inhibited(alerts) {
+			inhibited = append(inhibited, as.Alert.Labels["alertname"]+"@"+as.Alert.Labels["instance"])
+		}
+		sort.Strings(inhibited)
+		if strings.Join(inhibited, ",") != strings.Join(tt.inhibited, ",") {
+			t.Errorf("%s: inhibited %v, want %v", tt.name, inhibited, tt.inhibited)
+		}
+	}
+}
@@ -1,31 +1,73 @@
 package main
 
 import (
-	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
 	"encoding/json"
-	"flag"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"io/ioutil"
 	"log"
-
+	"log/slog"
+	"math/rand/v2"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-var (
-	token           = flag.String("slack_webhook", "", "slack webhook url")
-	redisURL        = flag.String("redis_url", "127.0.0.1:6379", "redis url")
-	expiration      = flag.Int64("expiration", 180, "expiration time in second")
-	freq            = flag.Int64("frequency", 60, "alert frequence in second")
-	listen          = flag.String("listen_addr", "0.0.0.0:19093", "listen address")
-	silenceDuration = flag.Int64("silence_duration", 60*60, "silence duration")
-	externalURL     = flag.String("external_url", "", "URL under which molert is externally reachable.")
-	redisClient     *redis.Client
-)
+var errNotFound = errors.New("alert not found")
+
+// Server holds the state shared by alerting and the http handlers
+type Server struct {
+	cfg       atomic.Pointer[Config]    // swapped by reload, read with config()
+	notifiers atomic.Pointer[notifiers] // built from cfg
+	redis     *redisConn
+	mux       *http.ServeMux
+
+	alerting chan struct{} // held while alert() runs
+
+	lastReceived int64        // unix time of last request to indexHandler
+	lastWarned   int64        // unix time of last watchdog warning
+	ready        int32        // 1 once startup is done
+	derivedURL   atomic.Value // string, the url of the first trusted request when external_url is unset
+}
+
+func newServer(config *Config) (*Server, error) {
+	redis, err := newRedisConn(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect redis %s: %s", config.RedisURL, err)
+	}
+	srv := &Server{
+		redis:    redis,
+		alerting: make(chan struct{}, 1),
+	}
+	srv.setConfig(config)
+	srv.mux = srv.routes()
+	return srv, nil
+}
+
+// config returns the running config
+func (srv *Server) config() *Config {
+	return srv.cfg.Load()
+}
 
 type Alert struct {
 	Labels       map[string]string `json:"labels"`
@@ -33,6 +75,8 @@ type Alert struct {
 	StartsAt     time.Time         `json:"startsAt"`
 	EndsAt       time.Time         `json:"endsAt"`
 	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint,omitempty"`
+	Status       string            `json:"status,omitempty"` // firing or resolved, sent by alertmanager and grafana
 }
 
 type Field struct {
@@ -41,21 +85,32 @@ type Field struct {
 	Short bool   `json:"short,omitempty"`
 }
 
+// Action is a link button of an attachment, or an interactive one posting name and value to /slack/action
+type Action struct {
+	Type  string `json:"type"`
+	Text  string `json:"text"`
+	URL   string `json:"url,omitempty"`
+	Name  string `json:"name,omitempty"`
+	Value string `json:"value,omitempty"`
+}
+
 type Attachment struct {
-	Fallback   string  `json:"fallback,omitempty"`
-	Color      string  `json:"color,omitempty"`
-	Pretext    string  `json:"pretext,omitempty"`
-	AuthorName string  `json:"author_name,omitempty"`
-	AuthorLink string  `json:"author_link,omitempty"`
-	Title      string  `json:"title,omitempty"`
-	TitleLink  string  `json:"title_link,omitempty"`
-	Text       string  `json:"text,omitempty"`
-	Fields     []Field `json:"fields,omitempty"`
-	ImageURL   string  `json:"image_url,omitempty"`
-	ThumbURL   string  `json:"thumb_url,omitempty"`
-	Footer     string  `json:"footer,omitempty"`
-	FooterIcon string  `json:"footer_icon,omitempty"`
-	Timestamp  int64   `json:"ts,omitempty"`
+	Fallback   string   `json:"fallback,omitempty"`
+	Color      string   `json:"color,omitempty"`
+	Pretext    string   `json:"pretext,omitempty"`
+	AuthorName string   `json:"author_name,omitempty"`
+	AuthorLink string   `json:"author_link,omitempty"`
+	Title      string   `json:"title,omitempty"`
+	TitleLink  string   `json:"title_link,omitempty"`
+	Text       string   `json:"text,omitempty"`
+	Fields     []Field  `json:"fields,omitempty"`
+	Actions    []Action `json:"actions,omitempty"`
+	ImageURL   string   `json:"image_url,omitempty"`
+	ThumbURL   string   `json:"thumb_url,omitempty"`
+	Footer     string   `json:"footer,omitempty"`
+	FooterIcon string   `json:"footer_icon,omitempty"`
+	Timestamp  int64    `json:"ts,omitempty"`
+	CallbackID string   `json:"callback_id,omitempty"`
 }
 
 type Payload struct {
@@ -65,272 +120,1720 @@ type Payload struct {
 	IconEmoji   string       `json:"icon_emoji,omitempty"`
 	IconURL     string       `json:"icon_url,omitempty"`
 	Attachments []Attachment `json:"attachments,omitempty"`
+	Webhook     string       `json:"-"`            // slack webhook to post to, default to slack_webhook
+	MessageTS   string       `json:"ts,omitempty"` // ts of the message to update with chat.update
+	ChannelID   string       `json:"-"`            // channel id of the message to update
+	Alerts      []*Alert     `json:"-"`            // alerts the payload is built from, one per attachment
+	SilenceCmd  string       `json:"-"`            // silence command of Text without the slack escaping
 }
 
 type Silence struct {
-	URL      string `json:"url"`
-	Duration int64  `json:"duration,omitempty"`
+	URL       string     `json:"url"`
+	Matchers  Matchers   `json:"matchers,omitempty"` // silence all alerts matching these instead of url
+	Duration  int64      `json:"duration,omitempty"`
+	StartsAt  *time.Time `json:"starts_at,omitempty"` // silence is pending until this time
+	CreatedBy string     `json:"created_by,omitempty"`
+}
+
+type AlertCount struct {
+	Total    int `json:"total"`
+	Critical int `json:"critical"`
+	Warning  int `json:"warning"`
+	Silenced int `json:"silenced"`
+}
+
+// AlertList is a page of /list, with the number of alerts matching its query
+type AlertList struct {
+	Alerts      []*AlertStatus `json:"alerts"`
+	Total       int            `json:"total"`
+	Maintenance bool           `json:"maintenance"` // no alert is sent while true
 }
 
 type AlertStatus struct {
-	Alert Alert `json:"alert"`
-	TTL   int64 `json:"ttl"` // -1: silence forever, 0: no silence, >0: silence n seconds
+	Alert        Alert  `json:"alert"`
+	TTL          int64  `json:"ttl"`                     // -1: silence forever, 0: no silence, >0: silence n seconds
+	LastNotified int64  `json:"last_notified,omitempty"` // unix time the alert was last sent to slack
+	RefiredAt    int64  `json:"refired_at,omitempty"`    // unix time the alert fired again within flap_suppress of resolving
+	ReceivedAt   int64  `json:"received_at,omitempty"`   // unix time the alert was first saved
+	SilencedBy   string `json:"silenced_by,omitempty"`
 }
 
-func init() {
-	flag.Parse()
-	var err error
-	var url string
-	if *redisURL == "" {
-		url = os.Getenv("REDIS_URL")
-	} else {
-		url = *redisURL
-	}
-	redisClient, err = redis.Dial("tcp", url)
-	if err != nil {
-		log.Fatalf("failed to connect redis: %s", url)
+// parseMap parses comma separated key=value pairs, eg. "critical=30m,warning=2h"
+func parseMap(s string) map[string]string {
+	m := map[string]string{}
+	for _, kv := range strings.Split(s, ",") {
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			continue
+		}
+		m[strings.TrimSpace(kv[:i])] = strings.TrimSpace(kv[i+1:])
 	}
+	return m
 }
 
 func main() {
-	ticker := time.NewTicker(time.Second * time.Duration(*freq))
+	config, err := loadConfig(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(config.logger)
+	slog.Info("starting molert", "version", version, "commit", commit, "build_date", buildDate)
+	srv, err := newServer(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	prometheus.MustRegister(activeAlerts{srv})
+	atomic.StoreInt64(&srv.lastReceived, time.Now().Unix())
+	done := make(chan struct{})
+	go srv.startup(done)
+	go srv.run(done)
+	go srv.reconcileLoop(done)
+	slog.Info("listening", "addr", srv.config().ListenAddr)
+	server := &http.Server{Addr: srv.config().ListenAddr}
+	var handler http.Handler = srv.mux
+	if srv.config().CORSOrigins != "" {
+		handler = srv.cors(handler)
+	}
+	if srv.config().AccessLog {
+		handler = accessLog(handler)
+	}
+	server.Handler = handler
+	if srv.config().TLSCert != "" || srv.config().TLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(srv.config().TLSCert, srv.config().TLSKey)
+		if err != nil {
+			log.Fatalf("failed to load tls cert %q and key %q: %s", srv.config().TLSCert, srv.config().TLSKey, err)
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	go func() {
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
 	go func() {
-		for _ = range ticker.C {
-			alert()
+		for range reload {
+			if err := srv.reload(); err != nil {
+				slog.Error("failed to reload config, keeping the running one", "err", err)
+			}
 		}
 	}()
-	log.Printf("listening on %s", *listen)
-	http.HandleFunc("/", indexHandler)
-	http.HandleFunc("/list", listHandler)
-	http.HandleFunc("/silence", silenceHandler)
-	log.Fatal(http.ListenAndServe(*listen, nil))
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	slog.Info("shutting down", "signal", <-sig)
+	close(done)
+	ctx, cancel := context.WithTimeout(context.Background(), srv.config().ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("failed to shutdown http server", "err", err)
+	}
+	select { // wait for a running alert() to finish its sends
+	case srv.alerting <- struct{}{}:
+	case <-ctx.Done():
+		slog.Warn("gave up waiting for alerts being sent")
+	}
+	srv.redis.Close()
+}
+
+// run calls tick every frequency seconds, each shifted by up to freq_jitter seconds either way, until done is closed
+func (srv *Server) run(done chan struct{}) {
+	for {
+		timer := time.NewTimer(srv.nextTick())
+		select {
+		case <-done:
+			timer.Stop()
+			return
+		case <-timer.C:
+			go srv.tick()
+		}
+	}
 }
 
-func alert() {
-	alerts := getAlerts()
+// nextTick returns the wait until the next tick
+func (srv *Server) nextTick() time.Duration {
+	d := time.Duration(srv.config().Frequency) * time.Second
+	if jitter := time.Duration(srv.config().FreqJitter) * time.Second; jitter > 0 {
+		d += time.Duration(rand.Int64N(int64(2*jitter+1))) - jitter
+	}
+	return d
+}
+
+// tick runs alert(), unless the previous run is still sending
+func (srv *Server) tick() {
+	select {
+	case srv.alerting <- struct{}{}:
+	default:
+		slog.Warn("previous alert run still in progress, skip this tick")
+		return
+	}
+	defer func() { <-srv.alerting }()
+	srv.alert()
+	srv.watchdog()
+}
+
+func (srv *Server) alert() {
+	if srv.inMaintenance() {
+		slog.Info("maintenance mode, no alert is sent")
+		return
+	}
+	srv.applyPendingSilences()
+	alerts, err := srv.getAlerts()
+	if err != nil {
+		slog.Error("failed to get alerts, none is sent", "err", err)
+		return
+	}
+	var batches []*batch
+	now := time.Now()
+	inhibited := srv.inhibited(alerts)
 	for _, alert := range alerts {
-		if alert.TTL == 0 {
-			payloads := alert.Alert.toPayloads()
-			for _, payload := range payloads {
-				payload.send()
+		if alert.Alert.resolved() {
+			// send the resolved message once, then forget the alert. An alert never sent firing,
+			// eg. muted, inhibited, flapping or still in group_wait, isn't sent resolved either
+			if alert.TTL == 0 && !srv.suppressed(&alert.Alert) && alert.LastNotified != 0 {
+				batches = append(batches, &batch{alert: alert, payloads: srv.toPayloads(&alert.Alert, alert.received())})
+			} else {
+				srv.remove(&alert.Alert)
+			}
+			continue
+		}
+		if alert.TTL == 0 && !srv.suppressed(&alert.Alert) && !srv.muted(&alert.Alert, now) && !srv.flapping(alert, now) && !srv.waiting(alert, now) && !inhibited[alert] && srv.due(alert) {
+			batches = append(batches, &batch{alert: alert, payloads: srv.toPayloads(&alert.Alert, alert.received())})
+		}
+	}
+	if srv.config().SlackBotToken != "" && !srv.config().GroupByChannel {
+		srv.loadMessages(batches)
+	}
+	srv.sendBatches(batches)
+	var sent, failed int
+	for _, b := range batches {
+		sent += b.sends
+		failed += int(b.failed)
+		if b.failed > 0 {
+			slog.Warn("messages failed for alert", "url", b.alert.Alert.GeneratorURL, "failed", b.failed, "messages", b.sends)
+		}
+		if b.sends > 0 && int(b.failed) == b.sends {
+			continue // nothing delivered, leave the alert as is to retry on next tick
+		}
+		if b.alert.Alert.resolved() {
+			srv.remove(&b.alert.Alert)
+		} else {
+			srv.notified(b.alert)
+			srv.saveMessages(b)
+		}
+	}
+	if sent > 0 {
+		slog.Info("sent messages", "sent", sent-failed, "alerts", len(batches), "failed", failed)
+	}
+}
+
+// batch is the payloads of one alert to send
+type batch struct {
+	alert    *AlertStatus
+	payloads []Payload
+	sem      chan struct{} // limits concurrent sends of this alert to alert_concurrency
+	sends    int           // slack messages and alert notifiers of the alert
+	failed   int32
+}
+
+// job is a payload to send and the batches of the alerts it carries,
+// or the alert of a single batch to send with an alert notifier
+type job struct {
+	batches []*batch
+	p       *Payload
+	n       AlertNotifier
+}
+
+// sendBatches sends payloads of all batches with send_concurrency workers
+func (srv *Server) sendBatches(batches []*batch) {
+	workers, perAlert := srv.config().SendConcurrency, srv.config().AlertConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	if perAlert < 1 {
+		perAlert = 1
+	}
+	ns := srv.notifiers.Load()
+	var jobs []job
+	switch {
+	case ns.chat == nil:
+	case srv.config().GroupByChannel:
+		jobs = groupByChannel(batches, srv.config().MaxAttachments)
+	default:
+		for _, b := range batches {
+			for i := range b.payloads {
+				jobs = append(jobs, job{batches: []*batch{b}, p: &b.payloads[i]})
+			}
+		}
+	}
+	for _, b := range batches {
+		b.sem = make(chan struct{}, perAlert)
+		if ns.chat != nil {
+			b.sends = len(b.payloads)
+		}
+		for _, n := range ns.alert {
+			jobs = append(jobs, job{batches: []*batch{b}, n: n})
+			b.sends++
+		}
+	}
+	queue := make(chan job)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				if len(j.batches) == 1 { // a grouped message isn't limited by alert_concurrency
+					j.batches[0].sem <- struct{}{}
+				}
+				var err error
+				if j.n != nil {
+					err = j.n.NotifyAlert(context.Background(), &j.batches[0].alert.Alert, j.batches[0].payloads)
+				} else {
+					err = ns.chat.Notify(context.Background(), j.p)
+				}
+				if len(j.batches) == 1 {
+					<-j.batches[0].sem
+				}
+				if err != nil {
+					for _, b := range j.batches {
+						atomic.AddInt32(&b.failed, 1)
+					}
+				}
+			}
+		}()
+	}
+	for _, j := range jobs {
+		queue <- j
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// groupByChannel merges the payloads going to the same webhook and channel into messages
+// of at most limit attachments, the silence command of each alert moves to its attachment
+func groupByChannel(batches []*batch, limit int) []job {
+	if limit < 1 {
+		limit = 1
+	}
+	var jobs []job
+	open := map[string]int{} // webhook and channel to index of the job being filled
+	for _, b := range batches {
+		for _, p := range b.payloads {
+			key := p.Webhook + " " + p.Channel
+			i, found := open[key]
+			if !found || len(jobs[i].p.Attachments) >= limit {
+				merged := p
+				merged.Text = ""
+				merged.Attachments = nil
+				merged.Alerts = nil
+				jobs = append(jobs, job{p: &merged})
+				i = len(jobs) - 1
+				open[key] = i
+			}
+			j := &jobs[i]
+			for _, a := range p.Attachments {
+				if p.Text != "" {
+					a.Text = strings.TrimSpace(a.Text + "\n" + p.Text)
+				}
+				j.p.Attachments = append(j.p.Attachments, a)
+			}
+			j.p.Alerts = append(j.p.Alerts, p.Alerts...)
+			j.batches = append(j.batches, b)
+		}
+	}
+	return jobs
+}
+
+// watchdog warns when no alert has been received for watchdog_timeout seconds,
+// the warning is repeated every watchdog_timeout seconds until alerts come back
+func (srv *Server) watchdog() {
+	if srv.config().WatchdogTimeout <= 0 {
+		return
+	}
+	now := time.Now().Unix()
+	last := atomic.LoadInt64(&srv.lastReceived)
+	if now-last < srv.config().WatchdogTimeout || now-srv.lastWarned < srv.config().WatchdogTimeout {
+		return
+	}
+	srv.lastWarned = now
+	slog.Warn("no alert received", "since", time.Unix(last, 0))
+	p := Payload{
+		Channel: srv.config().WatchdogChannel,
+		Attachments: []Attachment{{
+			Color:     "danger",
+			Title:     "No alerts received",
+			Text:      fmt.Sprintf("molert has not received any alert for %d seconds, check that alertmanager is still able to reach it", now-last),
+			Timestamp: last,
+		}},
+	}
+	srv.setIdentity(&p, "critical")
+	srv.notify(context.Background(), nil, []Payload{p})
+}
+
+// notify sends the payloads of alert a with slack and a once with every alert notifier,
+// returning the last error. a is nil for a message about no alert.
+func (srv *Server) notify(ctx context.Context, a *Alert, payloads []Payload) error {
+	var failed error
+	ns := srv.notifiers.Load()
+	if ns.chat != nil {
+		for i := range payloads {
+			if err := ns.chat.Notify(ctx, &payloads[i]); err != nil {
+				failed = err
 			}
 		}
 	}
+	for _, n := range ns.alert {
+		if err := n.NotifyAlert(ctx, a, payloads); err != nil {
+			failed = err
+		}
+	}
+	return failed
 }
 
-func indexHandler(w http.ResponseWriter, r *http.Request) {
+func (srv *Server) indexHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		srv.uiHandler(w, r)
+		return
+	}
 	defer r.Body.Close()
-	body, err := ioutil.ReadAll(r.Body)
+	atomic.StoreInt64(&srv.lastReceived, time.Now().Unix())
+	body, err := srv.bodyReader(w, r)
 	if err != nil {
-		log.Print(err)
+		slog.Warn("failed to read request", "err", err)
+		writeError(w, bodyErrorStatus(err), err)
+		return
 	}
-	var alerts []Alert
-	err = json.Unmarshal(body, &alerts)
+	defer body.Close()
+	alerts, status, err := decodeAlerts(body)
 	if err != nil {
-		log.Printf("failed to unmarshal incoming %s to []Alert", body)
+		slog.Warn("failed to decode incoming alerts", "err", err)
+		writeError(w, bodyErrorStatus(err), err)
+		return
 	}
+	alertsReceived.Add(float64(len(alerts)))
+	if status != "" {
+		slog.Debug("received alerts", "count", len(alerts), "status", status)
+	}
+	var added bool
 	for _, alert := range alerts {
-		alert.save()
+		isNew, err := srv.save(&alert)
+		if err != nil {
+			slog.Error("failed to save alert", "url", alert.GeneratorURL, "err", err)
+			writeError(w, redisErrorStatus(err), err)
+			return
+		}
+		added = added || isNew
+	}
+	// with a repeat interval, only new alerts are due, so send them right away instead of on next tick
+	if added && (srv.config().RepeatInterval > 0 || len(srv.config().repeatIntervals) > 0) {
+		if srv.config().GroupWait > 0 {
+			time.AfterFunc(srv.config().GroupWait, srv.tick)
+		} else {
+			go srv.tick()
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readBody reads the request body, up to max_body_bytes both before and after decompressing a gzip body
+func (srv *Server) readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body, err := srv.bodyReader(w, r)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return ioutil.ReadAll(body)
+}
+
+// bodyReader returns the request body for streaming, limited like readBody
+func (srv *Server) bodyReader(w http.ResponseWriter, r *http.Request) (io.ReadCloser, error) {
+	body := http.MaxBytesReader(w, r.Body, srv.config().MaxBodyBytes)
+	if !strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+		return body, nil
+	}
+	zr, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gzip body: %w", err)
+	}
+	return &limitedReader{ReadCloser: zr, left: srv.config().MaxBodyBytes, limit: srv.config().MaxBodyBytes}, nil
+}
+
+// limitedReader fails with *http.MaxBytesError once more than limit bytes are read
+type limitedReader struct {
+	io.ReadCloser
+	left, limit int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.left < 0 {
+		return 0, &http.MaxBytesError{Limit: l.limit}
+	}
+	if int64(len(p)) > l.left+1 {
+		p = p[:l.left+1]
+	}
+	n, err := l.ReadCloser.Read(p)
+	l.left -= int64(n)
+	if l.left < 0 {
+		return n, &http.MaxBytesError{Limit: l.limit}
+	}
+	return n, err
+}
+
+// bodyErrorStatus is the status answering a failed readBody
+func bodyErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// redisErrorStatus is the status answering a failed redis command, 503 when redis is unreachable
+// so that senders retry later
+func redisErrorStatus(err error) int {
+	var unavailable *redisUnavailableError
+	if errors.As(err, &unavailable) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+func (srv *Server) listHandler(w http.ResponseWriter, r *http.Request) {
+	as, err := srv.getAlerts()
+	if err != nil {
+		slog.Error("failed to list alerts", "err", err)
+		writeError(w, redisErrorStatus(err), err)
+		return
+	}
+	if ms := queryMatchers(r.URL.Query()); len(ms) > 0 {
+		matched := as[:0]
+		for _, a := range as {
+			if a.Alert.matches(ms) {
+				matched = append(matched, a)
+			}
+		}
+		as = matched
+	}
+	less, err := alertOrder(r.URL.Query().Get("sort"), srv.config())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	sort.SliceStable(as, func(i, j int) bool { return less(as[i], as[j]) })
+	total := len(as)
+	offset, limit, err := paging(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if offset > len(as) {
+		offset = len(as)
+	}
+	as = as[offset:]
+	if limit > 0 && limit < len(as) {
+		as = as[:limit]
+	}
+	if as == nil {
+		as = []*AlertStatus{}
+	}
+	maintenance := srv.inMaintenance()
+	w.Header().Set("X-Maintenance", strconv.FormatBool(maintenance))
+	writeJSON(w, http.StatusOK, AlertList{Alerts: as, Total: total, Maintenance: maintenance})
+}
+
+// alertOrder returns the less function of a /list sort parameter: starts_at, severity, alertname or url,
+// prefixed with - for descending order. Default to -starts_at, the newest alerts first. Severities rank by
+// the severity_levels of config
+func alertOrder(by string, config *Config) (func(a, b *AlertStatus) bool, error) {
+	if by == "" {
+		by = "-starts_at"
+	}
+	desc := strings.HasPrefix(by, "-")
+	var less func(a, b *AlertStatus) bool
+	switch strings.TrimPrefix(by, "-") {
+	case "starts_at":
+		less = func(a, b *AlertStatus) bool { return a.Alert.StartsAt.Before(b.Alert.StartsAt) }
+	case "severity":
+		less = func(a, b *AlertStatus) bool {
+			return config.severityRank(a.Alert.Labels["severity"]) < config.severityRank(b.Alert.Labels["severity"])
+		}
+	case "alertname":
+		less = func(a, b *AlertStatus) bool { return a.Alert.Labels["alertname"] < b.Alert.Labels["alertname"] }
+	case "url":
+		less = func(a, b *AlertStatus) bool { return a.Alert.GeneratorURL < b.Alert.GeneratorURL }
+	default:
+		return nil, fmt.Errorf("invalid sort %q, expected starts_at, severity, alertname or url", by)
+	}
+	if desc {
+		return func(a, b *AlertStatus) bool { return less(b, a) }, nil
+	}
+	return less, nil
+}
+
+// paging parses the offset and limit query parameters, limit 0 means no limit
+func paging(query url.Values) (offset, limit int, err error) {
+	if v := query.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("invalid offset %q", v)
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			return 0, 0, fmt.Errorf("invalid limit %q", v)
+		}
+	}
+	return offset, limit, nil
+}
+
+// routes returns the mux serving every endpoint
+func (srv *Server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", allow(srv.basicAuth(srv.indexHandler), http.MethodGet, http.MethodPost))
+	mux.HandleFunc("/list", allow(srv.basicAuth(srv.listHandler), http.MethodGet))
+	mux.HandleFunc("/silence", allow(srv.basicAuth(srv.silenceHandler), http.MethodPost))
+	mux.HandleFunc("/count", allow(srv.countHandler, http.MethodGet))
+	mux.HandleFunc("/maintenance", allow(srv.basicAuth(srv.maintenanceHandler), http.MethodGet, http.MethodPost))
+	mux.HandleFunc("/replay", allow(srv.basicAuth(srv.replayHandler), http.MethodPost))
+	mux.HandleFunc("/test", allow(srv.basicAuth(srv.testHandler), http.MethodPost))
+	mux.HandleFunc("/config", allow(srv.basicAuth(srv.configHandler), http.MethodGet))
+	mux.HandleFunc("/reload", allow(srv.basicAuth(srv.reloadHandler), http.MethodPost))
+	mux.HandleFunc("/unsilence", allow(srv.basicAuth(srv.unsilenceHandler), http.MethodPost))
+	mux.HandleFunc("/delete", allow(srv.basicAuth(srv.deleteHandler), http.MethodPost))
+	mux.HandleFunc("/silences", allow(srv.basicAuth(srv.silencesHandler), http.MethodGet))
+	mux.HandleFunc("/history", allow(srv.basicAuth(srv.historyHandler), http.MethodGet))
+	mux.HandleFunc("/pending_silences", allow(srv.basicAuth(srv.pendingSilencesHandler), http.MethodGet))
+	mux.HandleFunc("/healthz", allow(srv.healthHandler, http.MethodGet))
+	mux.HandleFunc("/ready", allow(srv.readyHandler, http.MethodGet))
+	mux.HandleFunc("/version", allow(srv.versionHandler, http.MethodGet))
+	mux.HandleFunc("/metrics", allow(promhttp.Handler().ServeHTTP, http.MethodGet))
+	mux.HandleFunc("/slack/action", allow(srv.slackActionHandler, http.MethodPost)) // authenticated by the slack signature
+	return mux
+}
+
+// allow answers 405 to requests whose method is not one of methods, HEAD is allowed with GET
+func allow(h http.HandlerFunc, methods ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, m := range methods {
+			if r.Method == m || (r.Method == http.MethodHead && m == http.MethodGet) {
+				h(w, r)
+				return
+			}
+		}
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// basicAuth requires the auth_user/auth_pass credentials when they are configured
+func (srv *Server) basicAuth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if srv.config().AuthUser == "" && srv.config().AuthPass == "" {
+			// without credentials only a request through a trusted proxy tells a url to trust
+			if srv.trustedProxy(r) {
+				srv.deriveExternalURL(r)
+			}
+			h(w, r)
+			return
+		}
+		user, pass, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(srv.config().AuthUser)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(pass), []byte(srv.config().AuthPass)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="molert"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		srv.deriveExternalURL(r)
+		h(w, r)
+	}
+}
+
+// healthHandler is a liveness probe, it only tells the http server responds
+func (srv *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+// readyHandler is a readiness probe, failing until startup is done and whenever redis is unreachable
+func (srv *Server) readyHandler(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&srv.ready) == 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("starting"))
+		return
+	}
+	pong, err := srv.redis.Cmd("PING").Str()
+	if err != nil || pong != "PONG" {
+		slog.Warn("readiness check failed", "err", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("redis unavailable"))
+		return
 	}
 	w.Write([]byte("ok"))
 }
 
-func listHandler(w http.ResponseWriter, r *http.Request) {
-	as := getAlerts()
-	json.NewEncoder(w).Encode(as)
+// startup waits until redis is reachable, prunes expired alerts and verifies the webhooks with verify_webhook, then marks molert ready
+func (srv *Server) startup(done chan struct{}) {
+	for {
+		pong, err := srv.redis.Cmd("PING").Str()
+		if err == nil && pong == "PONG" {
+			break
+		}
+		slog.Warn("redis unreachable, not ready yet", "err", err)
+		select {
+		case <-done:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+	srv.reconcile()
+	if srv.config().VerifyWebhook && !srv.config().DryRun {
+		srv.verifyWebhooks()
+	}
+	atomic.StoreInt32(&srv.ready, 1)
+	slog.Info("ready")
+}
+
+func (srv *Server) countHandler(w http.ResponseWriter, r *http.Request) {
+	as, err := srv.getAlerts()
+	if err != nil {
+		slog.Error("failed to count alerts", "err", err)
+		writeError(w, redisErrorStatus(err), err)
+		return
+	}
+	var c AlertCount
+	for _, a := range as {
+		c.Total++
+		if a.TTL != 0 {
+			c.Silenced++
+		}
+		switch a.Alert.Labels["severity"] {
+		case "critical":
+			c.Critical++
+		case "warning":
+			c.Warning++
+		}
+	}
+	// let badges cache the counts for one alert period
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", srv.config().Frequency))
+	json.NewEncoder(w).Encode(c)
 }
 
-func silenceHandler(w http.ResponseWriter, r *http.Request) {
-	body, err := ioutil.ReadAll(r.Body)
+func (srv *Server) silenceHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := srv.readBody(w, r)
 	if err != nil {
-		log.Print(err)
+		slog.Warn("failed to read request", "err", err)
+		writeError(w, bodyErrorStatus(err), err)
+		return
 	}
 	defer r.Body.Close()
 	var s Silence
 	err = json.Unmarshal(body, &s)
 	if err != nil {
-		log.Printf("failed to unmarshal incoming %s to Silence", body)
+		slog.Warn("failed to unmarshal incoming silence", "body", string(body), "err", err)
+		writeError(w, http.StatusBadRequest, err)
+		return
 	}
-	s.silence()
-	w.Write([]byte("ok"))
+	if s.CreatedBy == "" {
+		s.CreatedBy = createdBy(r)
+	}
+	if s.URL == "" && len(s.Matchers) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("either url or matchers is required"))
+		return
+	}
+	if s.StartsAt != nil && s.StartsAt.After(time.Now()) {
+		err = srv.schedule(&s)
+	} else {
+		err = srv.applySilence(&s)
+	}
+	if err != nil {
+		slog.Error("failed to silence alert", "url", s.URL, "err", err)
+		writeError(w, redisErrorStatus(err), err)
+		return
+	}
+	duration := srv.silenceDuration(&s)
+	result := map[string]interface{}{"status": "ok", "duration": duration}
+	if duration >= 0 {
+		start := time.Now()
+		if s.StartsAt != nil && s.StartsAt.After(start) {
+			start = *s.StartsAt
+		}
+		result["expires_at"] = start.Add(time.Duration(duration) * time.Second).UTC().Format(time.RFC3339)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (srv *Server) unsilenceHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := srv.readBody(w, r)
+	if err != nil {
+		slog.Warn("failed to read request", "err", err)
+		writeError(w, bodyErrorStatus(err), err)
+		return
+	}
+	defer r.Body.Close()
+	var s Silence
+	err = json.Unmarshal(body, &s)
+	if err != nil {
+		slog.Warn("failed to unmarshal incoming silence", "body", string(body), "err", err)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	err = srv.unsilence(&s)
+	if err == errNotFound {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to unsilence alert", "url", s.URL, "err", err)
+		writeError(w, redisErrorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// deleteHandler removes a stuck alert without waiting for it to expire
+func (srv *Server) deleteHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := srv.readBody(w, r)
+	if err != nil {
+		slog.Warn("failed to read request", "err", err)
+		writeError(w, bodyErrorStatus(err), err)
+		return
+	}
+	defer r.Body.Close()
+	var req struct {
+		URL string `json:"url"`
+	}
+	err = json.Unmarshal(body, &req)
+	if err != nil || req.URL == "" {
+		slog.Warn("failed to unmarshal incoming delete request", "body", string(body), "err", err)
+		writeError(w, http.StatusBadRequest, errors.New(`expected {"url": "..."}`))
+		return
+	}
+	err = srv.delete(req.URL)
+	if err == errNotFound {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to delete alert", "url", req.URL, "err", err)
+		writeError(w, redisErrorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// createdBy tells who sent the request, from the X-Created-By header or the basic auth user
+func createdBy(r *http.Request) string {
+	if by := r.Header.Get("X-Created-By"); by != "" {
+		return by
+	}
+	user, _, _ := r.BasicAuth()
+	return user
+}
+
+// testHandler sends a test alert to the requested channel, to check the slack webhook
+func (srv *Server) testHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := srv.readBody(w, r)
+	if err != nil {
+		slog.Warn("failed to read request", "err", err)
+		writeError(w, bodyErrorStatus(err), err)
+		return
+	}
+	defer r.Body.Close()
+	var req struct {
+		Channel string `json:"channel"`
+	}
+	err = json.Unmarshal(body, &req)
+	if err != nil || req.Channel == "" {
+		slog.Warn("failed to unmarshal incoming test request", "body", string(body), "err", err)
+		writeError(w, http.StatusBadRequest, errors.New(`expected {"channel": "#channel"}`))
+		return
+	}
+	a := Alert{
+		Labels: map[string]string{
+			"alertname": "MolertTest",
+			"severity":  "info",
+			"channels":  req.Channel,
+		},
+		Annotations: map[string]string{
+			"summary":     "Test alert",
+			"description": "This is a test alert sent by molert, the slack webhook works.",
+		},
+		StartsAt:     time.Now(),
+		GeneratorURL: srv.endpoint("test"),
+	}
+	if err := srv.notify(r.Context(), &a, srv.toPayloads(&a, time.Time{})); err != nil {
+		result := map[string]interface{}{"error": err.Error()}
+		if se, ok := err.(*webhookError); ok {
+			result["slack_status"] = se.StatusCode
+		}
+		writeJSON(w, http.StatusBadGateway, result)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// replayHandler sends the stored alert of the url query parameter again, marked as a replay in the footer
+func (srv *Server) replayHandler(w http.ResponseWriter, r *http.Request) {
+	url := r.URL.Query().Get("url")
+	if url == "" {
+		writeError(w, http.StatusBadRequest, errors.New("url is required"))
+		return
+	}
+	data, err := srv.redis.Cmd("HGET", srv.alertKey(url), "alert").Bytes()
+	if err != nil {
+		if resp := srv.redis.Cmd("EXISTS", srv.alertKey(url)); resp.Err != nil {
+			slog.Error("failed to get alert", "url", url, "command", "EXISTS", "err", resp.Err)
+			err = fmt.Errorf("failed to get alert %s: %w", url, resp.Err)
+			writeError(w, redisErrorStatus(err), err)
+			return
+		}
+		writeError(w, http.StatusNotFound, errNotFound)
+		return
+	}
+	var a Alert
+	if err = json.Unmarshal(data, &a); err != nil {
+		slog.Warn("failed to unmarshal alert", "url", url, "alert", string(data), "err", err)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	payloads := srv.toPayloads(&a, time.Time{})
+	for _, p := range payloads {
+		for i := range p.Attachments {
+			p.Attachments[i].Footer = appendFooter(p.Attachments[i].Footer, "replay")
+		}
+	}
+	if err := srv.notify(r.Context(), &a, payloads); err != nil {
+		result := map[string]interface{}{"error": err.Error()}
+		if se, ok := err.(*webhookError); ok {
+			result["slack_status"] = se.StatusCode
+		}
+		writeJSON(w, http.StatusBadGateway, result)
+		return
+	}
+	slog.Info("alert replayed", "url", url, "by", createdBy(r))
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// silencesHandler lists silenced alerts with their remaining silence duration
+func (srv *Server) silencesHandler(w http.ResponseWriter, r *http.Request) {
+	as, err := srv.getAlerts()
+	if err != nil {
+		slog.Error("failed to list silenced alerts", "err", err)
+		writeError(w, redisErrorStatus(err), err)
+		return
+	}
+	silenced := []*AlertStatus{}
+	for _, a := range as {
+		if a.TTL != 0 {
+			silenced = append(silenced, a)
+		}
+	}
+	json.NewEncoder(w).Encode(silenced)
+}
+
+func (srv *Server) pendingSilencesHandler(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(srv.getPendingSilences())
+}
+
+// key returns the redis key of name, one of alert_urls, pending_silences and maintenance,
+// in the redis_prefix namespace
+func (srv *Server) key(name string) string {
+	return srv.config().RedisPrefix + name
+}
+
+// alertKey returns the redis key of the alert hash of url, with hash_keys a short
+// sha256 of url since the full url is kept in the url field of the hash
+func (srv *Server) alertKey(url string) string {
+	if srv.config().HashKeys {
+		return fmt.Sprintf("%salert:%x", srv.config().RedisPrefix, sha256.Sum256([]byte(url)))
+	}
+	return srv.config().RedisPrefix + url
+}
+
+// urlKey returns the redis key of url in the kind namespace (eg. "history:"), hashed with hash_keys like alertKey
+func (srv *Server) urlKey(kind, url string) string {
+	if srv.config().HashKeys {
+		return fmt.Sprintf("%s%s%x", srv.config().RedisPrefix, kind, sha256.Sum256([]byte(url)))
+	}
+	return srv.config().RedisPrefix + kind + url
 }
 
-func getAlerts() []*AlertStatus {
+// alertURLs returns members of alert_urls, scanned incrementally not to block redis on a large set
+func (srv *Server) alertURLs() ([]string, error) {
+	var urls []string
+	seen := map[string]bool{} // SSCAN may return a member more than once
+	cursor := "0"
+	for {
+		resp := srv.redis.Cmd("SSCAN", srv.key("alert_urls"), cursor, "COUNT", srv.config().RedisScanCount)
+		if resp.Err != nil {
+			return nil, fmt.Errorf("failed to scan alert urls: %w", resp.Err)
+		}
+		reply, err := resp.Array()
+		if err != nil || len(reply) != 2 {
+			return nil, fmt.Errorf("expected alert url scan from %v", resp)
+		}
+		if cursor, err = reply[0].Str(); err != nil {
+			return nil, fmt.Errorf("expected scan cursor from %v", resp)
+		}
+		members, err := reply[1].List()
+		if err != nil {
+			return nil, fmt.Errorf("expected alert url list from %v", resp)
+		}
+		for _, url := range members {
+			if !seen[url] {
+				seen[url] = true
+				urls = append(urls, url)
+			}
+		}
+		if cursor == "0" {
+			return urls, nil
+		}
+	}
+}
+
+// getAlerts returns the stored alerts, failing if redis can't be reached
+func (srv *Server) getAlerts() ([]*AlertStatus, error) {
 	var as []*AlertStatus
-	resp := redisClient.Cmd("SMEMBERS", "alert_urls")
-	urls, err := resp.List()
+	urls, err := srv.alertURLs()
 	if err != nil {
-		log.Printf("expected alert url list from %v", resp)
-		return as
+		return nil, err
 	}
-	for _, url := range urls {
-		resp = redisClient.Cmd("HMGET", url, "alert", "silence")
-		result, err := resp.List()
+	// fetch all alerts in one round-trip, then ttls of the silenced ones in another
+	cmds := make([]redisCmd, len(urls))
+	for i, url := range urls {
+		cmds[i] = redisCmd{"HMGET", []interface{}{srv.alertKey(url), "alert", "silence", "last_notified", "silenced_by", "refired_at", "received_at"}}
+	}
+	var silenced []*AlertStatus
+	var ttlCmds []redisCmd
+	for i, resp := range srv.redis.Pipe(cmds) {
+		url := urls[i]
+		replies, err := resp.Array()
 		if err != nil {
-			log.Printf("expected alert payload and silence from %v", resp)
+			if resp.IsType(redis.IOErr) {
+				return nil, fmt.Errorf("failed to get alerts: %w", err)
+			}
+			slog.Warn("failed to get alert", "url", url, "command", "HMGET", "err", err)
 			continue
 		}
-		if len(result) != 2 {
+		if len(replies) != 6 {
+			slog.Warn("unexpected reply", "url", url, "command", "HMGET", "reply", resp)
 			continue
 		}
-		if result[0] == "" { // empty alert means alert expired, url should be removed from alert_urls set
-			resp = redisClient.Cmd("SREM", "alert_urls", url)
-			log.Printf("remove %s from alert_urls: %v", url, resp)
+		result := make([]string, len(replies))
+		for j, reply := range replies {
+			result[j], _ = reply.Str() // nil fields are left empty
+		}
+		// a nil alert means the key expired, an empty one a broken entry, either way
+		// the url should be removed from alert_urls set
+		if replies[0].IsType(redis.Nil) || result[0] == "" {
+			resp = srv.redis.Cmd("SREM", srv.key("alert_urls"), url)
+			slog.Debug("removed expired alert from alert_urls", "url", url, "reply", resp)
 			continue
 		}
 		var a Alert
 		err = json.Unmarshal([]byte(result[0]), &a)
 		if err != nil {
-			log.Printf("failed to unmarshal %s to Alert", result[0])
+			slog.Warn("failed to unmarshal alert", "url", url, "alert", result[0], "err", err)
 			continue
 		}
-		if result[1] != "true" { // not silenced
-			as = append(as, &AlertStatus{Alert: a, TTL: 0})
-			continue
+		lastNotified, _ := strconv.ParseInt(result[2], 10, 64)
+		refiredAt, _ := strconv.ParseInt(result[4], 10, 64)
+		receivedAt, _ := strconv.ParseInt(result[5], 10, 64)
+		status := &AlertStatus{Alert: a, TTL: 0, LastNotified: lastNotified, RefiredAt: refiredAt, ReceivedAt: receivedAt}
+		if result[1] == "true" {
+			status.SilencedBy = result[3]
+			silenced = append(silenced, status)
+			ttlCmds = append(ttlCmds, redisCmd{"TTL", []interface{}{srv.alertKey(url)}})
 		}
-		resp = redisClient.Cmd("TTL", url)
+		as = append(as, status)
+	}
+	if len(ttlCmds) == 0 {
+		return as, nil
+	}
+	dropped := map[*AlertStatus]bool{}
+	for i, resp := range srv.redis.Pipe(ttlCmds) {
 		ttl, err := resp.Int64()
-		if err != nil {
+		if err != nil && resp.IsType(redis.IOErr) {
+			return nil, fmt.Errorf("failed to get silence ttls: %w", err)
+		}
+		if err != nil || ttl == -2 { // -2: expired since HMGET
+			dropped[silenced[i]] = true
 			continue
 		}
-		as = append(as, &AlertStatus{Alert: a, TTL: ttl})
+		if ttl < 0 { // -1: no expiration, silenced forever
+			ttl = -1
+		}
+		silenced[i].TTL = ttl
+	}
+	kept := as[:0]
+	for _, status := range as {
+		if !dropped[status] {
+			kept = append(kept, status)
+		}
+	}
+	return kept, nil
+}
+
+// received returns the time the alert was first received, zero if unknown
+func (as *AlertStatus) received() time.Time {
+	if as.ReceivedAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(as.ReceivedAt, 0)
+}
+
+// waiting reports whether the alert was received less than group_wait ago, to give related alerts
+// time to arrive and be sent together
+func (srv *Server) waiting(as *AlertStatus, now time.Time) bool {
+	if as.ReceivedAt == 0 || as.LastNotified != 0 {
+		return false
+	}
+	return now.Sub(time.Unix(as.ReceivedAt, 0)) < srv.config().GroupWait
+}
+
+// due reports whether the repeat interval of the alert has passed since it was last sent
+func (srv *Server) due(as *AlertStatus) bool {
+	interval := srv.config().RepeatInterval
+	if d, found := srv.config().repeatIntervals[as.Alert.Labels["severity"]]; found {
+		interval = d
+	}
+	if interval <= 0 || as.LastNotified == 0 {
+		return true
 	}
-	return as
+	return time.Since(time.Unix(as.LastNotified, 0)) >= interval
 }
 
-func (a *Alert) toPayloads() []Payload {
+// notified records the time the alert was sent, it expires together with the alert
+func (srv *Server) notified(as *AlertStatus) {
+	as.LastNotified = time.Now().Unix()
+	if n, err := srv.redis.Cmd("EXISTS", srv.alertKey(as.Alert.GeneratorURL)).Int(); err != nil || n == 0 {
+		return // expired meanwhile, don't recreate it without expiration
+	}
+	resp := srv.redis.Cmd("HSET", srv.alertKey(as.Alert.GeneratorURL), "last_notified", as.LastNotified)
+	if resp.Err != nil {
+		slog.Warn("failed to save last notified time", "url", as.Alert.GeneratorURL, "command", "HSET", "err", resp.Err)
+	}
+}
+
+// resolved reports whether the alert has ended, as told by its status or its endsAt
+func (a *Alert) resolved() bool {
+	return a.Status == "resolved" || (!a.EndsAt.IsZero() && a.EndsAt.Before(time.Now()))
+}
+
+// remove deletes the alert from redis
+func (srv *Server) remove(a *Alert) {
+	resp := srv.redis.Cmd("DEL", srv.alertKey(a.GeneratorURL))
+	if resp.Err != nil {
+		slog.Warn("failed to delete alert", "url", a.GeneratorURL, "command", "DEL", "err", resp.Err)
+	}
+	resp = srv.redis.Cmd("SREM", srv.key("alert_urls"), a.GeneratorURL)
+	slog.Debug("removed alert from alert_urls", "url", a.GeneratorURL, "reply", resp)
+	srv.markResolved(a)
+}
+
+// toPayloads builds the messages of an alert, received is the time molert first received it if known
+func (srv *Server) toPayloads(a *Alert, received time.Time) []Payload {
 	attachment := Attachment{
-		Color:     "warning",
-		TitleLink: a.GeneratorURL,
-		Timestamp: a.StartsAt.Unix(),
+		Color:      "warning",
+		TitleLink:  a.GeneratorURL,
+		Timestamp:  a.StartsAt.Unix(),
+		FooterIcon: srv.config().FooterIcon,
+	}
+	if color, found := parseMap(srv.config().SeverityColors)[a.Labels["severity"]]; found {
+		attachment.Color = color
 	}
-	if summary, found := a.Annotations["summary"]; found {
-		attachment.Title = summary
+	if color, found := parseMap(srv.config().EnvColors)[a.Labels["env"]]; found {
+		attachment.Color = color
 	}
-	if description, found := a.Annotations["description"]; found {
-		attachment.Text = description
+	attachment.Title = srv.render("title", a)
+	attachment.Text = truncate(srv.render("text", a), srv.config().MaxTextLength)
+	attachment.Footer = srv.render("footer", a)
+	attachment.Fields = srv.fields(a)
+	if runbook, found := a.Annotations["runbook_url"]; found && validURL(runbook) {
+		attachment.Actions = []Action{{Type: "button", Text: "Runbook", URL: runbook}}
 	}
-	if env, found := a.Labels["env"]; found {
-		attachment.Footer = env
+	if srv.knownExternalURL() != "" && !a.resolved() {
+		link := srv.endpoint("") + "?url=" + url.QueryEscape(a.GeneratorURL)
+		attachment.Actions = append(attachment.Actions, Action{Type: "button", Text: "Open in molert", URL: link})
+	}
+	if srv.config().FooterAge && !a.StartsAt.IsZero() {
+		attachment.Footer = appendFooter(attachment.Footer, fmt.Sprintf("started %s ago", humanizeDuration(time.Since(a.StartsAt))))
+	}
+	if srv.config().FooterFingerprint {
+		fp := a.fingerprint()
+		if len(fp) > 8 {
+			fp = fp[:8]
+		}
+		attachment.Footer = appendFooter(attachment.Footer, fp)
+	}
+	if srv.config().FooterReceived && !received.IsZero() {
+		attachment.Footer = appendFooter(attachment.Footer, "received "+received.UTC().Format("Jan 2 15:04 MST"))
 	}
 
-	s, _ := json.Marshal(Silence{URL: a.GeneratorURL, Duration: *silenceDuration})
-	silenceCmd := fmt.Sprintf("`curl -XPOST %s/silence -H 'Content-Type: application/json' -d '%s'`", *externalURL, s)
+	s, _ := json.Marshal(Silence{URL: a.GeneratorURL, Duration: srv.config().SilenceDuration})
+	silenceCmd := fmt.Sprintf("curl -XPOST %s -H 'Content-Type: application/json' -d %s", shellQuote(srv.endpoint("silence")), shellQuote(string(s)))
+	if a.resolved() {
+		attachment.Color = "good"
+		attachment.Pretext = "Resolved"
+		attachment.Title = "[RESOLVED] " + attachment.Title
+		silenceCmd = ""
+	} else if srv.config().SlackSigningSecret != "" {
+		// an interactive button replaces the curl command
+		attachment.CallbackID = "silence"
+		attachment.Actions = append(attachment.Actions, Action{
+			Type:  "button",
+			Text:  "Silence " + humanizeDuration(time.Duration(srv.config().SilenceDuration)*time.Second),
+			Name:  "silence",
+			Value: a.GeneratorURL,
+		})
+		silenceCmd = ""
+	}
 
+	webhook := srv.config().webhooks[a.Labels[srv.config().WebhookLabel]]
+	targets := a.targets()
+	if len(targets) == 0 && srv.config().DefaultChannel != "" {
+		slog.Debug("alert has no users or channels label, sending to default channel", "url", a.GeneratorURL, "channel", srv.config().DefaultChannel)
+		targets = []string{srv.config().DefaultChannel}
+	}
+	var text string
+	if silenceCmd != "" {
+		text = "`" + slackEscape(silenceCmd) + "`"
+	}
 	var payloads []Payload
+	for _, target := range targets {
+		p := Payload{
+			Text:        text,
+			Attachments: []Attachment{attachment},
+			Channel:     target,
+			Webhook:     webhook,
+			Alerts:      []*Alert{a},
+			SilenceCmd:  silenceCmd,
+		}
+		srv.setIdentity(&p, a.Labels["severity"])
+		payloads = append(payloads, p)
+	}
+	return payloads
+}
+
+// endpoint returns the url of a molert endpoint under external_url
+func (srv *Server) endpoint(name string) string {
+	base := srv.externalURL()
+	u, err := url.Parse(base)
+	if err != nil {
+		return strings.TrimSuffix(base, "/") + "/" + name
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + name
+	u.RawPath = ""
+	return u.String()
+}
+
+// shellQuote quotes s as a single shell word
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// slackEscape escapes the characters slack uses for markup in message text
+func slackEscape(s string) string {
+	s = strings.Replace(s, "&", "&amp;", -1)
+	s = strings.Replace(s, "<", "&lt;", -1)
+	return strings.Replace(s, ">", "&gt;", -1)
+}
+
+// setIdentity sets the bot username and icon of the payload, severity_icons overriding the icon
+func (srv *Server) setIdentity(p *Payload, severity string) {
+	p.Username = srv.config().SlackUsername
+	icon := srv.config().SlackIconEmoji
+	if srv.config().SlackIconURL != "" {
+		icon = srv.config().SlackIconURL
+	}
+	if i, found := parseMap(srv.config().SeverityIcons)[severity]; found {
+		icon = i
+	}
+	if strings.HasPrefix(icon, ":") {
+		p.IconEmoji = icon
+	} else {
+		p.IconURL = icon
+	}
+}
+
+// fields returns the labels allowed by field_labels as short attachment fields, except routing labels
+func (srv *Server) fields(a *Alert) []Field {
+	allowed := map[string]bool{}
+	for _, name := range strings.Split(srv.config().FieldLabels, ",") {
+		allowed[strings.TrimSpace(name)] = true
+	}
+	names := make([]string, 0, len(a.Labels))
+	for name := range a.Labels {
+		if name == "users" || name == "channels" || !(allowed["*"] || allowed[name]) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var fields []Field
+	for _, name := range names {
+		fields = append(fields, Field{Title: name, Value: a.Labels[name], Short: true})
+	}
+	return fields
+}
+
+// targets returns the distinct slack users (@user) and channels the alert is routed to
+// by its users and channels labels
+func (a *Alert) targets() []string {
+	var targets []string
+	seen := map[string]bool{}
+	add := func(target string) {
+		if !seen[target] {
+			seen[target] = true
+			targets = append(targets, target)
+		}
+	}
 	if users, found := a.Labels["users"]; found {
-		for _, user := range strings.Split(strings.TrimSpace(users), ",") {
-			p := Payload{
-				Username:    "alert-bot",
-				IconEmoji:   ":loudspeaker:",
-				Text:        silenceCmd,
-				Attachments: []Attachment{attachment},
-				Channel:     fmt.Sprintf("@%s", strings.TrimSpace(user)),
+		for _, user := range strings.Split(users, ",") {
+			if user = strings.TrimLeft(strings.TrimSpace(user), "@"); user != "" { // skip empty elements of "a,,b,"
+				add("@" + user)
 			}
-			payloads = append(payloads, p)
 		}
 	}
 	if channels, found := a.Labels["channels"]; found {
-		for _, ch := range strings.Split(strings.TrimSpace(channels), ",") {
-			p := Payload{
-				Username:    "alert-bot",
-				IconEmoji:   ":loudspeaker:",
-				Text:        silenceCmd,
-				Attachments: []Attachment{attachment},
-				Channel:     strings.TrimSpace(ch),
+		for _, ch := range strings.Split(channels, ",") {
+			ch = strings.TrimSpace(ch)
+			if strings.HasPrefix(ch, "@") {
+				ch = "@" + strings.TrimLeft(ch, "@")
 			}
-			payloads = append(payloads, p)
+			if ch == "" || ch == "@" || ch == "#" {
+				continue
+			}
+			if !strings.HasPrefix(ch, "#") && !strings.HasPrefix(ch, "@") && !slackChannelID(ch) {
+				slog.Warn("channel without # or @, slack may post it to the default channel of the webhook", "url", a.GeneratorURL, "channel", ch)
+			}
+			add(ch)
 		}
 	}
-	return payloads
+	return targets
 }
 
-func (p *Payload) send() {
-	data, err := json.Marshal(p)
-	if err != nil {
-		log.Printf("failed to marshal %+v, alert would not sent", p)
-		return
+// slackChannelID reports whether s looks like a slack channel id (eg. C024BE91L), which needs no #
+func slackChannelID(s string) bool {
+	if len(s) < 9 || !strings.ContainsRune("CGD", rune(s[0])) {
+		return false
 	}
-	_, err = http.Post(*token, "application/json", bytes.NewBuffer(data))
-	if err != nil {
-		log.Printf("failed to send alert %s: %v", data, err)
+	for _, c := range s {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// suppressed reports whether the alert is below min_severity or carries one of suppress_annotations,
+// such alert is stored but never sent to slack
+func (srv *Server) suppressed(a *Alert) bool {
+	if config := srv.config(); config.severityRank(a.Labels["severity"]) < config.severityRank(config.MinSeverity) {
+		return true
+	}
+	for _, s := range strings.Split(srv.config().SuppressAnnotations, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		name, want := s, ""
+		if i := strings.Index(s, "="); i >= 0 {
+			name, want = s[:i], s[i+1:]
+		}
+		value, found := a.Annotations[name]
+		if found && (want == "" || value == want) {
+			return true
+		}
+	}
+	return false
+}
+
+func appendFooter(footer, s string) string {
+	if footer == "" {
+		return s
+	}
+	return footer + " | " + s
+}
+
+// fingerprint returns the fingerprint sent by alertmanager, or computes one from the labels the same way
+func (a *Alert) fingerprint() string {
+	if a.Fingerprint != "" {
+		return a.Fingerprint
+	}
+	names := make([]string, 0, len(a.Labels))
+	for name := range a.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := fnv.New64a()
+	for _, name := range names {
+		h.Write([]byte(name))
+		h.Write([]byte{255})
+		h.Write([]byte(a.Labels[name]))
+		h.Write([]byte{255})
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// humanizeDuration formats d as a short relative age, eg. 45s, 23m, 5h12m, 3d4h
+func humanizeDuration(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int64(d/time.Second))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int64(d/time.Minute))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh%dm", int64(d/time.Hour), int64(d%time.Hour/time.Minute))
+	}
+	return fmt.Sprintf("%dd%dh", int64(d/(24*time.Hour)), int64(d%(24*time.Hour)/time.Hour))
+}
+
+// annotation returns the named annotation, whitespace normalized if configured by normalize_annotations
+func (srv *Server) annotation(a *Alert, name string) (string, bool) {
+	value, found := a.Annotations[name]
+	if !found {
+		return value, found
+	}
+	for _, n := range strings.Split(srv.config().NormalizeAnnotations, ",") {
+		if n = strings.TrimSpace(n); n == "*" || n == name {
+			return normalizeWhitespace(value), found
+		}
+	}
+	return value, found
+}
+
+// truncate cuts s to limit runes, ending with an ellipsis and a note of how much was cut, 0 to keep s whole
+func truncate(s string, limit int) string {
+	n := utf8.RuneCountInString(s)
+	if limit <= 0 || n <= limit {
+		return s
 	}
+	runes := []rune(s)
+	return fmt.Sprintf("%s…\n_(truncated, %d more characters)_", string(runes[:limit]), n-limit)
 }
 
-// save alert to redis
-func (a *Alert) save() {
+// normalizeWhitespace converts CRLFs to newlines, trims trailing spaces of every line and drops blank lines
+func normalizeWhitespace(s string) string {
+	s = strings.Replace(s, "\r\n", "\n", -1)
+	s = strings.Replace(s, "\r", "\n", -1)
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimRight(line, " \t")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// expiration returns the seconds the alert is kept: the molert_expiration annotation if it is
+// a positive number, else the time until endsAt if it is in the future (at most max_expiration),
+// else the expiration flag. fromEndsAt reports whether it was computed from endsAt.
+func (srv *Server) expiration(a *Alert) (seconds int64, fromEndsAt bool) {
+	if value, found := a.Annotations["molert_expiration"]; found {
+		seconds, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err == nil && seconds > 0 {
+			return seconds, false
+		}
+		slog.Warn("invalid molert_expiration, this will be ignored", "url", a.GeneratorURL, "value", value)
+	}
+	if until := time.Until(a.EndsAt); !a.EndsAt.IsZero() && until > 0 {
+		if seconds = int64(until/time.Second) + 1; seconds > srv.config().MaxExpiration {
+			seconds = srv.config().MaxExpiration
+		}
+		seconds += 2 * srv.config().Frequency // keep it until alert() has sent the resolved message
+		return seconds, true
+	}
+	return srv.config().Expiration, false
+}
+
+// validURL reports whether s is an absolute url, which is required for alerts since it is their redis key
+func validURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.IsAbs() && u.Host != ""
+}
+
+// save alert to redis, reporting whether it is a new alert
+func (srv *Server) save(a *Alert) (bool, error) {
+	if !validURL(a.GeneratorURL) {
+		slog.Warn("alert has no valid generatorURL, this will be ignored", "alertname", a.Labels["alertname"], "url", a.GeneratorURL)
+		return false, nil
+	}
 	data, err := json.Marshal(a)
 	if err != nil {
-		log.Printf("failed to marshal %+v: %s", a, err.Error())
-		return
+		return false, fmt.Errorf("failed to marshal %+v: %s", a, err)
 	}
-	resp := redisClient.Cmd("SADD", "alert_urls", a.GeneratorURL)
-	statusCode, err := resp.Int() // should return Int 1
+	srv.record(a)
+	resp := srv.redis.Cmd("SADD", srv.key("alert_urls"), a.GeneratorURL)
+	added, err := resp.Int() // 1 for a new alert
 	if err != nil {
-		log.Printf("failed to save alert %s: %s", a.GeneratorURL, err.Error())
-		return
+		return false, fmt.Errorf("failed to save alert %s: %w", a.GeneratorURL, err)
 	}
 	// check alert status
-	resp = redisClient.Cmd("HGET", a.GeneratorURL, "silence")
+	resp = srv.redis.Cmd("HGET", srv.alertKey(a.GeneratorURL), "silence")
 	r, err := resp.Str()
 	if err == nil && r == "true" {
-		log.Printf("alert %s already silenced, this will be ignored", a.GeneratorURL)
-		return
+		if a.resolved() { // no resolved message for a silenced alert, forget it right away
+			slog.Debug("silenced alert resolved, removing it", "url", a.GeneratorURL)
+			srv.remove(a)
+			return false, nil
+		}
+		slog.Debug("alert already silenced, this will be ignored", "url", a.GeneratorURL)
+		return false, nil
 	}
 	// add alert to redis
-	resp = redisClient.Cmd("HMSET", a.GeneratorURL, map[string]string{
-		"alert":   string(data),
-		"silence": "false",
-	})
-	status, err := resp.Str() // should return Str "OK"
+	resp = srv.redis.Cmd("HSET", srv.alertKey(a.GeneratorURL), "alert", data, "silence", "false", "url", a.GeneratorURL)
+	fields, err := resp.Int() // number of new fields, 0 when the alert is updated
 	if err != nil {
-		log.Printf("failed to save alert %s: %s", a.GeneratorURL, err.Error())
-		return
+		return false, fmt.Errorf("failed to save alert %s: %w", a.GeneratorURL, err)
 	}
-	if status == "OK" {
-		log.Print("added successfully")
+	if fields > 0 { // new alert
+		if resp = srv.redis.Cmd("HSET", srv.alertKey(a.GeneratorURL), "received_at", time.Now().Unix()); resp.Err != nil {
+			slog.Warn("failed to save received time", "url", a.GeneratorURL, "command", "HSET", "err", resp.Err)
+		}
+		if !a.resolved() {
+			srv.markRefired(a)
+		}
+		if len(a.targets()) == 0 && srv.config().DefaultChannel != "" { // warned once here rather than on every tick
+			slog.Warn("alert has no users or channels label, sending to default channel", "url", a.GeneratorURL, "channel", srv.config().DefaultChannel)
+		}
 	}
-	// check alert ttl
-	resp = redisClient.Cmd("TTL", a.GeneratorURL)
+	slog.Debug("alert saved", "url", a.GeneratorURL)
+	// check alert ttl, an expiration following endsAt is refreshed on every save
+	expiration, fromEndsAt := srv.expiration(a)
+	resp = srv.redis.Cmd("TTL", srv.alertKey(a.GeneratorURL))
 	ttl, err := resp.Int()
-	if err == nil && ttl >= 0 {
-		log.Printf("expiration for %s already set to %d, this will be ignored", a.GeneratorURL, ttl)
-		return
+	if err == nil && ttl >= 0 && !fromEndsAt {
+		slog.Debug("expiration already set, this will be ignored", "url", a.GeneratorURL, "ttl", ttl)
+		return added == 1, nil
 	}
 	// set expiration
-	resp = redisClient.Cmd("EXPIRE", a.GeneratorURL, *expiration)
-	statusCode, err = resp.Int()
+	resp = srv.redis.Cmd("EXPIRE", srv.alertKey(a.GeneratorURL), expiration)
+	statusCode, err := resp.Int()
 	if err != nil {
-		log.Printf("failed to set expiration for %s: %s", a.GeneratorURL, err.Error())
-		return
+		return false, fmt.Errorf("failed to set expiration for %s: %w", a.GeneratorURL, err)
 	}
 	if statusCode == 1 {
-		log.Printf("expiration for %s set successfully", a.GeneratorURL)
+		slog.Debug("expiration set", "url", a.GeneratorURL, "seconds", expiration)
+	}
+	return added == 1, nil
+}
+
+// apply silences the alert of url, or every alert matching all matchers
+func (srv *Server) applySilence(s *Silence) error {
+	if len(s.Matchers) == 0 {
+		return srv.silence(s)
+	}
+	as, err := srv.getAlerts()
+	if err != nil {
+		return err
 	}
+	for _, a := range as {
+		if !a.Alert.matches(s.Matchers) {
+			continue
+		}
+		matched := Silence{URL: a.Alert.GeneratorURL, Duration: s.Duration, CreatedBy: s.CreatedBy}
+		if err := srv.silence(&matched); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches reports whether the labels of the alert match all matchers
+func (a *Alert) matches(ms Matchers) bool {
+	for i := range ms {
+		if !ms[i].matches(a.Labels[ms[i].Name]) {
+			return false
+		}
+	}
+	return true
 }
 
 // silence make alert silence
-func (s *Silence) silence() {
-	resp := redisClient.Cmd("HSET", s.URL, "silence", "true")
+func (srv *Server) silence(s *Silence) error {
+	resp := srv.redis.Cmd("HSET", srv.alertKey(s.URL), "silence", "true")
 	statusCode, err := resp.Int()
 	if err != nil {
-		log.Printf("failed to silence alert %s: %s", s.URL, err.Error())
-		return
+		return fmt.Errorf("failed to silence alert %s: %w", s.URL, err)
 	}
 	if statusCode == 1 {
-		log.Printf("alert %s was silenced successfully", s.URL)
+		slog.Info("alert silenced", "url", s.URL, "created_by", s.CreatedBy)
+	}
+	if s.CreatedBy != "" {
+		resp = srv.redis.Cmd("HSET", srv.alertKey(s.URL), "silenced_by", s.CreatedBy)
+	} else {
+		resp = srv.redis.Cmd("HDEL", srv.alertKey(s.URL), "silenced_by")
+	}
+	if resp.Err != nil {
+		return fmt.Errorf("failed to save who silenced alert %s: %w", s.URL, resp.Err)
 	}
 	if s.Duration < 0 { // silence forever
-		resp = redisClient.Cmd("PERSIST", s.URL)
-		log.Printf("silenced %s forever", s.URL)
-		return
+		resp = srv.redis.Cmd("PERSIST", srv.alertKey(s.URL))
+		if resp.Err != nil {
+			return fmt.Errorf("failed to silence alert %s forever: %w", s.URL, resp.Err)
+		}
+		slog.Info("silenced forever", "url", s.URL)
+		return nil
 	}
 	if s.Duration == 0 { // silence for default duration
-		resp = redisClient.Cmd("EXPIRE", s.URL, *silenceDuration)
-		log.Printf("silenced %s for default duration", s.URL)
-		return
+		resp = srv.redis.Cmd("EXPIRE", srv.alertKey(s.URL), srv.config().SilenceDuration)
+		if resp.Err != nil {
+			return fmt.Errorf("failed to set silence duration for %s: %w", s.URL, resp.Err)
+		}
+		slog.Info("silenced for default duration", "url", s.URL, "seconds", srv.config().SilenceDuration)
+		return nil
 	}
 	// silence for given duration, use small positive integer(eg. 1) to un-silence an alert
-	resp = redisClient.Cmd("EXPIRE", s.URL, s.Duration)
-	log.Printf("silenced %s for %d seconds", s.URL, s.Duration)
+	resp = srv.redis.Cmd("EXPIRE", srv.alertKey(s.URL), s.Duration)
+	if resp.Err != nil {
+		return fmt.Errorf("failed to set silence duration for %s: %w", s.URL, resp.Err)
+	}
+	slog.Info("silenced", "url", s.URL, "seconds", s.Duration)
+	return nil
+}
+
+// silenceDuration returns how many seconds the silence lasts, -1 for ever
+func (srv *Server) silenceDuration(s *Silence) int64 {
+	switch {
+	case s.Duration < 0:
+		return -1
+	case s.Duration == 0:
+		return srv.config().SilenceDuration
+	}
+	return s.Duration
+}
+
+// unsilence make a silenced alert fire again, it expires like a new alert
+func (srv *Server) unsilence(s *Silence) error {
+	exists, err := srv.redis.Cmd("HEXISTS", srv.alertKey(s.URL), "alert").Int()
+	if err != nil {
+		return fmt.Errorf("failed to check alert %s: %w", s.URL, err)
+	}
+	if exists == 0 {
+		return errNotFound
+	}
+	resp := srv.redis.Cmd("HSET", srv.alertKey(s.URL), "silence", "false")
+	if resp.Err != nil {
+		return fmt.Errorf("failed to unsilence alert %s: %w", s.URL, resp.Err)
+	}
+	srv.redis.Cmd("HDEL", srv.alertKey(s.URL), "silenced_by")
+	resp = srv.redis.Cmd("EXPIRE", srv.alertKey(s.URL), srv.config().Expiration)
+	if resp.Err != nil {
+		return fmt.Errorf("failed to set expiration for %s: %w", s.URL, resp.Err)
+	}
+	slog.Info("unsilenced", "url", s.URL)
+	return nil
+}
+
+// delete removes the alert of url right away, unlike remove it isn't taken as resolved
+func (srv *Server) delete(url string) error {
+	resps := srv.redis.Pipe([]redisCmd{
+		{"DEL", []interface{}{srv.alertKey(url)}},
+		{"SREM", []interface{}{srv.key("alert_urls"), url}},
+	})
+	deleted, err := resps[0].Int()
+	if err != nil {
+		return fmt.Errorf("failed to delete alert %s: %w", url, err)
+	}
+	removed, err := resps[1].Int()
+	if err != nil {
+		return fmt.Errorf("failed to remove alert %s from alert_urls: %w", url, err)
+	}
+	if deleted == 0 && removed == 0 {
+		return errNotFound
+	}
+	slog.Info("deleted", "url", url)
+	return nil
+}
+
+// schedule stores the silence as pending, it is applied by alert() once starts_at passes
+func (srv *Server) schedule(s *Silence) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %+v: %s", s, err)
+	}
+	resp := srv.redis.Cmd("ZADD", srv.key("pending_silences"), s.StartsAt.Unix(), data)
+	if resp.Err != nil {
+		return fmt.Errorf("failed to schedule silence for %s: %w", s.URL, resp.Err)
+	}
+	slog.Info("scheduled silence", "url", s.URL, "matchers", s.Matchers, "starts_at", s.StartsAt)
+	return nil
+}
+
+func (srv *Server) getPendingSilences() []*Silence {
+	ss := []*Silence{}
+	resp := srv.redis.Cmd("ZRANGE", srv.key("pending_silences"), 0, -1)
+	members, err := resp.List()
+	if err != nil {
+		slog.Warn("expected pending silence list", "command", "ZRANGE", "reply", resp)
+		return ss
+	}
+	for _, m := range members {
+		var s Silence
+		if err := json.Unmarshal([]byte(m), &s); err != nil {
+			slog.Warn("failed to unmarshal pending silence", "silence", m, "err", err)
+			continue
+		}
+		ss = append(ss, &s)
+	}
+	return ss
+}
+
+// applyPendingSilences silences alerts of pending silences whose start time has passed,
+// they expire at starts_at + duration
+func (srv *Server) applyPendingSilences() {
+	now := time.Now()
+	resp := srv.redis.Cmd("ZRANGEBYSCORE", srv.key("pending_silences"), "-inf", now.Unix())
+	members, err := resp.List()
+	if err != nil {
+		slog.Warn("expected pending silence list", "command", "ZRANGEBYSCORE", "reply", resp)
+		return
+	}
+	for _, m := range members {
+		srv.redis.Cmd("ZREM", srv.key("pending_silences"), m)
+		var s Silence
+		if err := json.Unmarshal([]byte(m), &s); err != nil || s.StartsAt == nil {
+			slog.Warn("failed to unmarshal pending silence", "silence", m, "err", err)
+			continue
+		}
+		if s.Duration < 0 { // silence forever
+			if err := srv.applySilence(&s); err != nil {
+				slog.Error("failed to apply pending silence", "url", s.URL, "err", err)
+			}
+			continue
+		}
+		duration := s.Duration
+		if duration == 0 {
+			duration = srv.config().SilenceDuration
+		}
+		remaining := s.StartsAt.Unix() + duration - now.Unix()
+		if remaining <= 0 {
+			slog.Info("pending silence already ended", "url", s.URL, "matchers", s.Matchers)
+			continue
+		}
+		active := Silence{URL: s.URL, Matchers: s.Matchers, Duration: remaining, CreatedBy: s.CreatedBy}
+		if err := srv.applySilence(&active); err != nil {
+			slog.Error("failed to apply pending silence", "url", s.URL, "err", err)
+		}
+	}
 }
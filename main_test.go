@@ -0,0 +1,639 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testAlert returns a firing alert sent to #ops, labels are given as name, value pairs
+func testAlert(name string, labels ...string) Alert {
+	a := Alert{
+		Labels:       map[string]string{"alertname": name, "severity": "warning", "channels": "#ops", "instance": "host1"},
+		Annotations:  map[string]string{"summary": name + " on host1"},
+		GeneratorURL: "http://prometheus:9090/graph?g0.expr=" + name,
+	}
+	for i := 0; i+1 < len(labels); i += 2 {
+		a.Labels[labels[i]] = labels[i+1]
+	}
+	return a
+}
+
+// listAlerts returns the alerts of srv, failing the test if they can't be read
+func listAlerts(t testing.TB, srv *Server) []*AlertStatus {
+	as, err := srv.getAlerts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return as
+}
+
+// count returns how many times name occurs in names
+func count(names []string, name string) int {
+	n := 0
+	for _, s := range names {
+		if s == name {
+			n++
+		}
+	}
+	return n
+}
+
+func TestResolvedSentOnlyAfterFiring(t *testing.T) {
+	rules := filepath.Join(t.TempDir(), "inhibit_rules.json")
+	err := os.WriteFile(rules, []byte(`[{"source_matchers": {"alertname": "HostDown"}, "target_matchers": {"alertname": "ServiceDown"}, "equal": ["instance"]}]`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		args   []string
+		firing bool // received firing before it resolves
+		want   bool // resolved message sent
+		flap   bool // resolved shortly before it fires again
+	}{
+		{"sent firing", nil, true, true, false},
+		{"first received resolved", nil, false, false, false},
+		{"muted", []string{"-mute_windows=Sun-Sat 00:00-24:00"}, true, false, false},
+		{"inhibited", []string{"-inhibit_rules=" + rules}, true, false, false},
+		{"group_wait", []string{"-group_wait=1h"}, true, false, false},
+		{"flapping", []string{"-flap_suppress=1h"}, true, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, notifier := newTestServer(t, tt.args...)
+			source := testAlert("HostDown", "severity", "critical")
+			alert := testAlert("ServiceDown")
+			if !tt.firing {
+				alert.Status = "resolved"
+			}
+			if tt.flap {
+				srv.markResolved(&alert)
+			}
+			for _, a := range []*Alert{&source, &alert} {
+				if _, err := srv.save(a); err != nil {
+					t.Fatal(err)
+				}
+			}
+			srv.alert()
+			firing := count(notifier.sent(), "ServiceDown")
+			alert.Status = "resolved"
+			if _, err := srv.save(&alert); err != nil {
+				t.Fatal(err)
+			}
+			srv.alert()
+			resolved := count(notifier.sent(), "ServiceDown") - firing
+			if got := resolved == 1; got != tt.want {
+				t.Errorf("resolved messages = %d, want sent %v", resolved, tt.want)
+			}
+			for _, as := range listAlerts(t, srv) {
+				if as.Alert.Labels["alertname"] == "ServiceDown" {
+					t.Errorf("resolved alert was not removed")
+				}
+			}
+			// another alertmanager of an HA pair delivers the resolved alert again
+			if _, err := srv.save(&alert); err != nil {
+				t.Fatal(err)
+			}
+			srv.alert()
+			if n := count(notifier.sent(), "ServiceDown") - firing - resolved; n != 0 {
+				t.Errorf("re-delivered resolved alert sent %d times", n)
+			}
+		})
+	}
+}
+
+func TestFailedSendRetried(t *testing.T) {
+	srv, notifier := newTestServer(t)
+	alert := testAlert("ServiceDown")
+	if _, err := srv.save(&alert); err != nil {
+		t.Fatal(err)
+	}
+	notifier.err = errors.New("slack is down")
+	srv.alert()
+	for _, as := range listAlerts(t, srv) {
+		if as.LastNotified != 0 {
+			t.Errorf("alert marked notified although nothing was delivered")
+		}
+	}
+	notifier.err = nil
+	srv.alert()
+	if n := count(notifier.sent(), "ServiceDown"); n != 1 {
+		t.Fatalf("firing messages = %d, want 1", n)
+	}
+	alert.Status = "resolved"
+	if _, err := srv.save(&alert); err != nil {
+		t.Fatal(err)
+	}
+	notifier.err = errors.New("slack is down")
+	srv.alert()
+	if len(listAlerts(t, srv)) != 1 {
+		t.Errorf("resolved alert removed although nothing was delivered")
+	}
+	notifier.err = nil
+	srv.alert()
+	if n := count(notifier.sent(), "ServiceDown"); n != 2 {
+		t.Errorf("messages = %d, want the firing and the resolved one", n)
+	}
+	if len(listAlerts(t, srv)) != 0 {
+		t.Errorf("resolved alert not removed once delivered")
+	}
+}
+
+func TestAlertNotifiedOncePerAlert(t *testing.T) {
+	srv, notifier := newTestServer(t)
+	pager := &testAlertNotifier{}
+	srv.notifiers.Store(&notifiers{chat: notifier, alert: []AlertNotifier{pager}})
+	alerts := []Alert{
+		testAlert("ServiceDown", "channels", "#ops,#dev", "users", "@oncall"),
+		testAlert("DiskFull", "channels", ""), // no target, no default_channel
+	}
+	for i := range alerts {
+		if _, err := srv.save(&alerts[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv.alert()
+	if n := count(notifier.sent(), "ServiceDown"); n != 3 {
+		t.Errorf("slack messages = %d, want one per target", n)
+	}
+	for _, name := range []string{"ServiceDown", "DiskFull"} {
+		if n := count(pager.alerts, name); n != 1 {
+			t.Errorf("%s notified %d times, want once", name, n)
+		}
+	}
+}
+
+func TestListHandler(t *testing.T) {
+	srv, _ := newTestServer(t)
+	for _, a := range []Alert{
+		testAlert("ServiceDown", "severity", "critical"),
+		testAlert("DiskFull"),
+		testAlert("HighLatency"),
+	} {
+		if _, err := srv.save(&a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		query string
+		names []string
+		total int
+	}{
+		{"", nil, 3},
+		{"?sort=alertname&limit=2", []string{"DiskFull", "HighLatency"}, 3},
+		{"?sort=alertname&offset=2", []string{"ServiceDown"}, 3},
+		{"?label.severity=critical", []string{"ServiceDown"}, 1},
+		{"?label.severity=info", []string{}, 0},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		srv.listHandler(w, httptest.NewRequest(http.MethodGet, "/list"+tt.query, nil))
+		var list AlertList
+		if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+			t.Fatalf("%s: %s", tt.query, err)
+		}
+		if list.Total != tt.total || list.Alerts == nil {
+			t.Errorf("%s: total = %d with alerts %v, want %d", tt.query, list.Total, list.Alerts, tt.total)
+		}
+		if tt.names == nil {
+			continue
+		}
+		var names []string
+		for _, as := range list.Alerts {
+			names = append(names, as.Alert.Labels["alertname"])
+		}
+		if strings.Join(names, ",") != strings.Join(tt.names, ",") {
+			t.Errorf("%s: alerts = %v, want %v", tt.query, names, tt.names)
+		}
+	}
+	for _, maintenance := range []bool{true, false} {
+		if err := srv.applyMaintenance(&Maintenance{Enabled: maintenance}); err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		srv.listHandler(w, httptest.NewRequest(http.MethodGet, "/list", nil))
+		var list AlertList
+		if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+			t.Fatal(err)
+		}
+		if list.Maintenance != maintenance || w.Header().Get("X-Maintenance") != strconv.FormatBool(maintenance) {
+			t.Errorf("maintenance = %v, want %v", list.Maintenance, maintenance)
+		}
+	}
+}
+
+func TestToPayloadsDeduplicated(t *testing.T) {
+	srv, _ := newTestServer(t)
+	tests := []struct {
+		users, channels string
+		want            []string
+	}{
+		{"", "#ops", []string{"#ops"}},
+		{"alice,bob", "#ops", []string{"@alice", "@bob", "#ops"}},
+		{"alice, @alice,@@alice", "", []string{"@alice"}},
+		{"alice", "@alice,#ops, #ops", []string{"@alice", "#ops"}},
+		{" bob ", "#ops,@bob", []string{"@bob", "#ops"}},
+	}
+	for _, tt := range tests {
+		a := testAlert("ServiceDown", "users", tt.users, "channels", tt.channels)
+		var channels []string
+		for _, p := range srv.toPayloads(&a, time.Time{}) {
+			channels = append(channels, p.Channel)
+		}
+		if strings.Join(channels, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("users %q, channels %q: payloads to %v, want %v", tt.users, tt.channels, channels, tt.want)
+		}
+	}
+}
+
+func TestSaveSkipsInvalidURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool // saved
+	}{
+		{"", false},
+		{"graph?g0.expr=up", false},
+		{"/graph?g0.expr=up", false},
+		{"http://", false},
+		{"http://prometheus:9090/graph?g0.expr=up", true},
+	}
+	for _, tt := range tests {
+		srv, _ := newTestServer(t)
+		a := testAlert("ServiceDown")
+		a.GeneratorURL = tt.url
+		if _, err := srv.save(&a); err != nil {
+			t.Fatalf("%q: %s", tt.url, err)
+		}
+		n, err := srv.redis.Cmd("SCARD", srv.key("alert_urls")).Int()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := n == 1 && len(listAlerts(t, srv)) == 1; got != tt.want {
+			t.Errorf("%q: %d alert urls, want saved %v", tt.url, n, tt.want)
+		}
+	}
+}
+
+func BenchmarkGetAlerts(b *testing.B) {
+	fr, addr := startFakeRedis(b)
+	srv, _ := newTestServerOn(b, addr)
+	for i := 0; i < 500; i++ {
+		a := testAlert("ServiceDown", "instance", fmt.Sprintf("host%d", i))
+		a.GeneratorURL += fmt.Sprintf("&instance=host%d", i)
+		if _, err := srv.save(&a); err != nil {
+			b.Fatal(err)
+		}
+		if i%10 == 0 { // silenced alerts take a TTL each
+			if err := srv.silence(&Silence{URL: a.GeneratorURL}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	reads := atomic.LoadInt64(&fr.reads)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if n := len(listAlerts(b, srv)); n != 500 {
+			b.Fatalf("got %d alerts, want 500", n)
+		}
+	}
+	b.ReportMetric(float64(atomic.LoadInt64(&fr.reads)-reads)/float64(b.N), "roundtrips/op")
+}
+
+func TestSaveWritesFields(t *testing.T) {
+	srv, _ := newTestServer(t)
+	a := testAlert("ServiceDown")
+	tests := []struct {
+		summary string
+		isNew   bool
+	}{
+		{"first", true},    // HSET replies 3 new fields
+		{"updated", false}, // HSET replies 0
+	}
+	for _, tt := range tests {
+		a.Annotations["summary"] = tt.summary
+		isNew, err := srv.save(&a)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.summary, err)
+		}
+		if isNew != tt.isNew {
+			t.Errorf("%s: new = %v, want %v", tt.summary, isNew, tt.isNew)
+		}
+		key := srv.alertKey(a.GeneratorURL)
+		values, err := srv.redis.Cmd("HMGET", key, "alert", "silence", "url", "received_at").List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var saved Alert
+		if err := json.Unmarshal([]byte(values[0]), &saved); err != nil {
+			t.Fatalf("%s: %s", tt.summary, err)
+		}
+		if saved.Annotations["summary"] != tt.summary || values[1] != "false" || values[2] != a.GeneratorURL || values[3] == "" {
+			t.Errorf("%s: fields = %q", tt.summary, values)
+		}
+		if ttl, err := srv.redis.Cmd("TTL", key).Int(); err != nil || ttl <= 0 {
+			t.Errorf("%s: ttl = %d, %v, want an expiration", tt.summary, ttl, err)
+		}
+	}
+}
+
+func TestExpiration(t *testing.T) {
+	srv, _ := newTestServer(t, "-expiration=180", "-max_expiration=86400", "-frequency=60")
+	tests := []struct {
+		name       string
+		endsAt     time.Duration // from now, 0 for no endsAt
+		annotation string
+		want       int64
+		fromEndsAt bool
+	}{
+		{"zero", 0, "", 180, false},
+		{"past", -time.Hour, "", 180, false},
+		{"future", 10 * time.Minute, "", 600 + 2*60, true},
+		{"clamped", 48 * time.Hour, "", 86400 + 2*60, true},
+		{"annotation", 10 * time.Minute, "300", 300, false},
+		{"invalid annotation", 0, "-1", 180, false},
+	}
+	for _, tt := range tests {
+		a := testAlert("ServiceDown")
+		if tt.endsAt != 0 {
+			a.EndsAt = time.Now().Add(tt.endsAt)
+		}
+		if tt.annotation != "" {
+			a.Annotations["molert_expiration"] = tt.annotation
+		}
+		seconds, fromEndsAt := srv.expiration(&a)
+		// the time until endsAt is rounded up
+		if (seconds != tt.want && seconds != tt.want+1) || fromEndsAt != tt.fromEndsAt {
+			t.Errorf("%s: expiration = %d, %v, want %d, %v", tt.name, seconds, fromEndsAt, tt.want, tt.fromEndsAt)
+		}
+	}
+}
+
+func TestIndexHandler(t *testing.T) {
+	alert := `{"labels": {"alertname": "ServiceDown", "channels": "#ops"}, "generatorURL": "http://prometheus:9090/graph?g0.expr=up"}`
+	tests := []struct {
+		name   string
+		body   string
+		code   int
+		alerts int
+	}{
+		{"alerts", `[` + alert + `]`, http.StatusOK, 1},
+		{"webhook message", `{"version": "4", "status": "firing", "alerts": [` + alert + `]}`, http.StatusOK, 1},
+		{"grafana message", `{"orgId": 1, "status": "firing", "alerts": [` + alert + `]}`, http.StatusOK, 1},
+		{"no alerts", `[]`, http.StatusOK, 0},
+		{"invalid json", `[` + alert, http.StatusBadRequest, 0},
+		{"invalid webhook message", `{"status": "firing", "alerts": [` + alert + `]`, http.StatusBadRequest, 0},
+		{"trailing data", `{"status": "firing", "alerts": []} {}`, http.StatusBadRequest, 0},
+		{"empty body", ``, http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		srv, notifier := newTestServer(t)
+		w := httptest.NewRecorder()
+		srv.indexHandler(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body)))
+		if w.Code != tt.code {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.code)
+		}
+		if n := len(listAlerts(t, srv)); n != tt.alerts {
+			t.Errorf("%s: %d alerts saved, want %d", tt.name, n, tt.alerts)
+		}
+		srv.alert()
+		if n := count(notifier.sent(), "ServiceDown"); n != tt.alerts {
+			t.Errorf("%s: %d messages sent, want %d", tt.name, n, tt.alerts)
+		}
+	}
+}
+
+func TestDecodeAlerts(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		status     string
+		alert      string // status of the alert
+		annotation string // value annotation of the alert
+		ended      bool
+	}{
+		{"alerts", `[{"labels": {"alertname": "A"}}]`, "", "", "", false},
+		{"alertmanager firing", `{"version": "4", "status": "firing", "alerts": [{"labels": {"alertname": "A"}}]}`,
+			"firing", "firing", "", false},
+		{"alertmanager resolved", `{"version": "4", "status": "resolved", "alerts": [{"labels": {"alertname": "A"}}]}`,
+			"resolved", "resolved", "", true},
+		{"grafana", `{"orgId": 1, "status": "firing", "alerts": [{"status": "firing", "labels": {"alertname": "A"}, "valueString": "B0=1"}]}`,
+			"firing", "firing", "B0=1", false},
+	}
+	for _, tt := range tests {
+		alerts, status, err := decodeAlerts(strings.NewReader(tt.body))
+		if err != nil || len(alerts) != 1 {
+			t.Fatalf("%s: decodeAlerts = %v, %v", tt.name, alerts, err)
+		}
+		a := alerts[0]
+		if status != tt.status || a.Status != tt.alert || a.Annotations["value"] != tt.annotation || !a.EndsAt.IsZero() != tt.ended {
+			t.Errorf("%s: status %q, alert %+v, want status %q, alert status %q, value %q, ended %v",
+				tt.name, status, a, tt.status, tt.alert, tt.annotation, tt.ended)
+		}
+	}
+}
+
+func TestSilenceCommandEscaped(t *testing.T) {
+	tests := []struct {
+		externalURL string
+		url         string
+		endpoint    string
+	}{
+		{"http://molert:8080", "http://prometheus:9090/graph?g0.expr=up&g0.tab=1", "http://molert:8080/silence"},
+		{"http://molert:8080/alerts/", "http://prometheus:9090/graph?g0.expr=up{job=\"a b\"}", "http://molert:8080/alerts/silence"},
+		{"https://example.com/molert", "http://prometheus:9090/graph?g0.expr=up{job='it's'}", "https://example.com/molert/silence"},
+		{"http://molert:8080", "http://prometheus:9090/graph?g0.expr=up<1>&g0.tab=0", "http://molert:8080/silence"},
+	}
+	unescape := strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">")
+	for _, tt := range tests {
+		srv, _ := newTestServer(t, "-external_url="+tt.externalURL, "-silence_duration=3600")
+		a := testAlert("ServiceDown")
+		a.GeneratorURL = tt.url
+		text := srv.toPayloads(&a, time.Time{})[0].Text
+		if strings.ContainsAny(strings.Replace(text, "&amp;", "", -1), "<>&") {
+			t.Errorf("%s: slack markup not escaped in %s", tt.url, text)
+		}
+		s, _ := json.Marshal(Silence{URL: tt.url, Duration: 3600})
+		want := "curl -XPOST " + shellQuote(tt.endpoint) + " -H 'Content-Type: application/json' -d " + shellQuote(string(s))
+		if got := unescape.Replace(strings.Trim(text, "`")); got != want {
+			t.Errorf("%s: silence command = %s, want %s", tt.url, got, want)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"", "''"},
+		{"a b&c", "'a b&c'"},
+		{`{"url": "x"}`, `'{"url": "x"}'`},
+		{"it's", `'it'\''s'`},
+		{"''", `''\'''\'''`},
+	}
+	for _, tt := range tests {
+		if got := shellQuote(tt.s); got != tt.want {
+			t.Errorf("shellQuote(%q) = %s, want %s", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestRedisDown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close() // nothing listens there anymore
+	srv, _ := newTestServerOn(t, addr, "-redis_retries=0")
+	tests := []struct {
+		handler http.HandlerFunc
+		method  string
+		path    string
+		body    string
+	}{
+		{srv.indexHandler, http.MethodPost, "/", `[{"labels": {"alertname": "ServiceDown"}, "generatorURL": "http://prometheus:9090/graph?g0.expr=up"}]`},
+		{srv.silenceHandler, http.MethodPost, "/silence", `{"url": "http://prometheus:9090/graph?g0.expr=up"}`},
+		{srv.silenceHandler, http.MethodPost, "/silence", `{"matchers": {"alertname": "ServiceDown"}}`},
+		{srv.unsilenceHandler, http.MethodPost, "/unsilence", `{"url": "http://prometheus:9090/graph?g0.expr=up"}`},
+		{srv.listHandler, http.MethodGet, "/list", ""},
+		{srv.countHandler, http.MethodGet, "/count", ""},
+		{srv.silencesHandler, http.MethodGet, "/silences", ""},
+		{srv.indexHandler, http.MethodGet, "/", ""}, // the ui
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		tt.handler(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s %s %s: status = %d, want %d", tt.method, tt.path, tt.body, w.Code, http.StatusServiceUnavailable)
+		}
+	}
+}
+
+func TestSilencedTTL(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration int64 // of the silence, 0 for none
+		expire   bool  // the key expires between HMGET and TTL
+		want     int64 // ttl of the alert status, -2 when it is dropped
+	}{
+		{"not silenced", 0, false, 0},
+		{"forever", -1, false, -1},
+		{"for a while", 600, false, 600},
+		{"expired meanwhile", 600, true, -2},
+	}
+	for _, tt := range tests {
+		fr, addr := startFakeRedis(t)
+		srv, _ := newTestServerOn(t, addr)
+		a := testAlert("ServiceDown")
+		if _, err := srv.save(&a); err != nil {
+			t.Fatal(err)
+		}
+		if tt.duration != 0 {
+			if err := srv.silence(&Silence{URL: a.GeneratorURL, Duration: tt.duration}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if tt.expire {
+			fr.mu.Lock()
+			fr.before = func(cmd string, args []string) {
+				if cmd == "TTL" {
+					fr.del(args[0])
+				}
+			}
+			fr.mu.Unlock()
+		}
+		ttl := int64(-2)
+		for _, as := range listAlerts(t, srv) {
+			ttl = as.TTL
+		}
+		// a positive ttl may have ticked down since the silence
+		if ttl != tt.want && (tt.want <= 0 || ttl < tt.want-1 || ttl > tt.want) {
+			t.Errorf("%s: ttl = %d, want %d", tt.name, ttl, tt.want)
+		}
+	}
+}
+
+// gzipped compresses s
+func gzipped(s string) string {
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Write([]byte(s))
+	zw.Close()
+	return b.String()
+}
+
+func TestIndexHandlerGzip(t *testing.T) {
+	alerts := `[{"labels": {"alertname": "ServiceDown", "channels": "#ops"}, "generatorURL": "http://prometheus:9090/graph?g0.expr=up"}]`
+	tests := []struct {
+		name     string
+		encoding string
+		body     string
+		code     int
+	}{
+		{"gzip", "gzip", gzipped(alerts), http.StatusOK},
+		{"upper case", "GZIP", gzipped(alerts), http.StatusOK},
+		{"webhook message", "gzip", gzipped(`{"version": "4", "status": "firing", "alerts": ` + alerts + `}`), http.StatusOK},
+		{"plain", "", alerts, http.StatusOK},
+		{"not gzip", "gzip", alerts, http.StatusBadRequest},
+		{"too large once decompressed", "gzip", gzipped(`[` + strings.Repeat(" ", 1<<20) + `]`), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		srv, _ := newTestServer(t, "-max_body_bytes=65536")
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+		if tt.encoding != "" {
+			r.Header.Set("Content-Encoding", tt.encoding)
+		}
+		w := httptest.NewRecorder()
+		srv.indexHandler(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, w.Code, tt.code, w.Body)
+		}
+		if saved := len(listAlerts(t, srv)) == 1; saved != (tt.code == http.StatusOK) {
+			t.Errorf("%s: alert saved %v", tt.name, saved)
+		}
+	}
+}
+
+func TestTargets(t *testing.T) {
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelWarn})))
+	tests := []struct {
+		users, channels string
+		want            []string
+		warned          bool
+	}{
+		{"a,,b,", "", []string{"@a", "@b"}, false},
+		{"", "#a,,#b,", []string{"#a", "#b"}, false},
+		{" , ,@", "#,@", nil, false},
+		{"@@a", "@@b", []string{"@a", "@b"}, false},
+		{"", "ops", []string{"ops"}, true},
+		{"", "#ops, dev", []string{"#ops", "dev"}, true},
+		{"", "C024BE91L", []string{"C024BE91L"}, false}, // a channel id
+	}
+	for _, tt := range tests {
+		logs.Reset()
+		a := testAlert("ServiceDown", "users", tt.users, "channels", tt.channels)
+		targets := a.targets()
+		if strings.Join(targets, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("users %q, channels %q: targets = %v, want %v", tt.users, tt.channels, targets, tt.want)
+		}
+		if warned := strings.Contains(logs.String(), "channel without # or @"); warned != tt.warned {
+			t.Errorf("users %q, channels %q: warned %v, want %v", tt.users, tt.channels, warned, tt.warned)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// Maintenance switches global maintenance mode, during which no alert is sent
+type Maintenance struct {
+	Enabled  bool  `json:"enabled"`
+	Duration int64 `json:"duration,omitempty"` // 0: silence_duration, <0: until disabled
+}
+
+type MaintenanceStatus struct {
+	Enabled bool  `json:"enabled"`
+	TTL     int64 `json:"ttl,omitempty"` // -1: until disabled, >0: remaining seconds
+}
+
+// maintenanceHandler reports maintenance mode on GET and switches it on POST
+func (srv *Server) maintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		body, err := srv.readBody(w, r)
+		if err != nil {
+			slog.Warn("failed to read request", "err", err)
+			writeError(w, bodyErrorStatus(err), err)
+			return
+		}
+		defer r.Body.Close()
+		var m Maintenance
+		err = json.Unmarshal(body, &m)
+		if err != nil {
+			slog.Warn("failed to unmarshal incoming maintenance", "body", string(body), "err", err)
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err = srv.applyMaintenance(&m); err != nil {
+			slog.Error("failed to switch maintenance", "enabled", m.Enabled, "err", err)
+			writeError(w, redisErrorStatus(err), err)
+			return
+		}
+	}
+	status, err := srv.getMaintenance()
+	if err != nil {
+		slog.Error("failed to get maintenance", "err", err)
+		writeError(w, redisErrorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (srv *Server) applyMaintenance(m *Maintenance) error {
+	if !m.Enabled {
+		if resp := srv.redis.Cmd("DEL", srv.key("maintenance")); resp.Err != nil {
+			return fmt.Errorf("failed to disable maintenance: %w", resp.Err)
+		}
+		slog.Info("maintenance disabled")
+		return nil
+	}
+	duration := m.Duration
+	if duration == 0 {
+		duration = srv.config().SilenceDuration
+	}
+	args := []interface{}{srv.key("maintenance"), "true"}
+	if duration > 0 {
+		args = append(args, "EX", duration)
+	}
+	resp := srv.redis.Cmd("SET", args...)
+	if resp.Err != nil {
+		return fmt.Errorf("failed to enable maintenance: %w", resp.Err)
+	}
+	slog.Info("maintenance enabled", "seconds", duration)
+	return nil
+}
+
+func (srv *Server) getMaintenance() (*MaintenanceStatus, error) {
+	ttl, err := srv.redis.Cmd("TTL", srv.key("maintenance")).Int64()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get maintenance: %w", err)
+	}
+	if ttl == -2 { // no key
+		return &MaintenanceStatus{}, nil
+	}
+	return &MaintenanceStatus{Enabled: true, TTL: ttl}, nil
+}
+
+// inMaintenance reports whether maintenance mode is on, assuming it is off if redis fails
+func (srv *Server) inMaintenance() bool {
+	status, err := srv.getMaintenance()
+	if err != nil {
+		slog.Error("failed to get maintenance, assuming it is off", "err", err)
+		return false
+	}
+	return status.Enabled
+}
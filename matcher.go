@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Matcher matches a label value, either literally or with an anchored regex like prometheus
+type Matcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// Matchers is unmarshaled either from a list of matchers, or from an object of label name to literal value
+type Matchers []Matcher
+
+func (ms *Matchers) UnmarshalJSON(data []byte) error {
+	var labels map[string]string
+	if err := json.Unmarshal(data, &labels); err == nil {
+		names := make([]string, 0, len(labels))
+		for name := range labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		*ms = nil
+		for _, name := range names {
+			*ms = append(*ms, Matcher{Name: name, Value: labels[name]})
+		}
+		return nil
+	}
+	var list []Matcher
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	for i := range list {
+		if !list[i].IsRegex {
+			continue
+		}
+		re, err := regexp.Compile("^(?:" + list[i].Value + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid regex for label %s: %s", list[i].Name, err)
+		}
+		list[i].re = re
+	}
+	*ms = list
+	return nil
+}
+
+func (m *Matcher) matches(value string) bool {
+	if m.re != nil {
+		return m.re.MatchString(value)
+	}
+	return value == m.Value
+}
+
+// queryMatchers returns literal matchers from label.<name>=<value> query parameters
+func queryMatchers(query url.Values) Matchers {
+	var ms Matchers
+	for key, values := range query {
+		if !strings.HasPrefix(key, "label.") {
+			continue
+		}
+		for _, value := range values {
+			ms = append(ms, Matcher{Name: strings.TrimPrefix(key, "label."), Value: value})
+		}
+	}
+	return ms
+}
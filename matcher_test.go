@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSilenceMatchers(t *testing.T) {
+	tests := []struct {
+		matchers string
+		code     int
+		silenced []string // instances
+	}{
+		{`{"instance": "web-1"}`, http.StatusOK, []string{"web-1"}},
+		{`[{"name": "instance", "value": "web-1"}]`, http.StatusOK, []string{"web-1"}},
+		{`[{"name": "instance", "value": "web-.*"}]`, http.StatusOK, nil}, // literal, not a regex
+		{`[{"name": "instance", "value": "web-.*", "isRegex": true}]`, http.StatusOK, []string{"web-1", "web-2"}},
+		{`[{"name": "instance", "value": "web", "isRegex": true}]`, http.StatusOK, nil}, // anchored
+		{`[{"name": "instance", "value": "web-1|db-1", "isRegex": true}]`, http.StatusOK, []string{"db-1", "web-1"}},
+		{`[{"name": "instance", "value": "web-.*", "isRegex": true}, {"name": "severity", "value": "critical"}]`, http.StatusOK, []string{"web-2"}},
+		{`[{"name": "instance", "value": "web-(", "isRegex": true}]`, http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		srv, _ := newTestServer(t)
+		for _, instance := range []string{"web-1", "web-2", "db-1"} {
+			a := testAlert("ServiceDown", "instance", instance)
+			a.GeneratorURL += "&instance=" + instance
+			if instance == "web-2" {
+				a.Labels["severity"] = "critical"
+			}
+			if _, err := srv.save(&a); err != nil {
+				t.Fatal(err)
+			}
+		}
+		w := httptest.NewRecorder()
+		body := strings.NewReader(`{"matchers": ` + tt.matchers + `}`)
+		srv.silenceHandler(w, httptest.NewRequest(http.MethodPost, "/silence", body))
+		if w.Code != tt.code {
+			t.Errorf("%s: status = %d, want %d", tt.matchers, w.Code, tt.code)
+		}
+		var silenced []string
+		for _, as := range listAlerts(t, srv) {
+			if as.TTL != 0 {
+				silenced = append(silenced, as.Alert.Labels["instance"])
+			}
+		}
+		sort.Strings(silenced)
+		if strings.Join(silenced, ",") != strings.Join(tt.silenced, ",") {
+			t.Errorf("%s: silenced %v, want %v", tt.matchers, silenced, tt.silenced)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metrics are exposed at /metrics by promhttp, besides the process_ and go_ metrics of the default registry
+
+var (
+	alertsReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "molert_alerts_received_total",
+		Help: "Alerts received from alertmanager.",
+	})
+	alertsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "molert_alerts_sent_total",
+		Help: "Messages sent successfully, by notifier (slack, teams, discord or pagerduty).",
+	}, []string{"notifier"})
+	sendsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "molert_sends_failed_total",
+		Help: "Messages failed to send, by notifier (slack, teams, discord or pagerduty).",
+	}, []string{"notifier"})
+	sendDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "molert_send_duration_seconds",
+		Help:    "Latency of posts to slack, teams, discord and pagerduty, by notifier.",
+		Buckets: []float64{.05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"notifier"})
+)
+
+func init() {
+	prometheus.MustRegister(alertsReceived, alertsSent, sendsFailed, sendDuration)
+}
+
+var activeAlertsDesc = prometheus.NewDesc("molert_active_alerts", "Alerts currently stored.", nil, nil)
+
+// activeAlerts collects the number of alerts stored in redis on every scrape
+type activeAlerts struct {
+	srv *Server
+}
+
+func (c activeAlerts) Describe(ch chan<- *prometheus.Desc) {
+	ch <- activeAlertsDesc
+}
+
+func (c activeAlerts) Collect(ch chan<- prometheus.Metric) {
+	active, err := c.srv.redis.Cmd("SCARD", c.srv.key("alert_urls")).Int()
+	if err != nil {
+		slog.Warn("failed to count active alerts", "command", "SCARD", "err", err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(activeAlertsDesc, prometheus.GaugeValue, float64(active))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// muteWindow is a daily time range on some weekdays, eg. "Mon-Fri 22:00-06:00".
+// A range ending before it starts crosses midnight and belongs to the day it starts,
+// so the example also mutes Saturday until 06:00 but not Monday.
+type muteWindow struct {
+	days       [7]bool
+	start, end int // minutes since midnight
+}
+
+// parseMuteWindows parses comma separated windows like "Mon-Fri 22:00-06:00,Sat-Sun 00:00-24:00"
+func parseMuteWindows(s string) ([]muteWindow, error) {
+	var windows []muteWindow
+	for _, w := range strings.Split(s, ",") {
+		w = strings.TrimSpace(w)
+		if w == "" {
+			continue
+		}
+		fields := strings.Fields(w)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid mute window %q, expected like \"Mon-Fri 22:00-06:00\"", w)
+		}
+		var mw muteWindow
+		from, to, err := parseRange(fields[0], parseWeekday)
+		if err != nil {
+			return nil, fmt.Errorf("invalid days in mute window %q: %s", w, err)
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			mw.days[d] = true
+			if d == to {
+				break
+			}
+		}
+		if mw.start, mw.end, err = parseRange(fields[1], parseClock); err != nil {
+			return nil, fmt.Errorf("invalid time in mute window %q: %s", w, err)
+		}
+		windows = append(windows, mw)
+	}
+	return windows, nil
+}
+
+// parseRange parses "a-b" or a single "a" with parse
+func parseRange(s string, parse func(string) (int, error)) (int, int, error) {
+	parts := strings.SplitN(s, "-", 2)
+	from, err := parse(parts[0])
+	if err != nil || len(parts) == 1 {
+		return from, from, err
+	}
+	to, err := parse(parts[1])
+	return from, to, err
+}
+
+func parseWeekday(s string) (int, error) {
+	d, found := weekdays[strings.ToLower(s)]
+	if !found {
+		return 0, fmt.Errorf("unknown weekday %q", s)
+	}
+	return int(d), nil
+}
+
+func parseClock(s string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil || h < 0 || h > 24 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return h*60 + m, nil
+}
+
+func (mw *muteWindow) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	today := int(t.Weekday())
+	if mw.start <= mw.end {
+		return mw.days[today] && minute >= mw.start && minute < mw.end
+	}
+	// crossing midnight
+	yesterday := (today + 6) % 7
+	return (mw.days[today] && minute >= mw.start) || (mw.days[yesterday] && minute < mw.end)
+}
+
+// muted reports whether now is in a mute window and the alert's severity is below mute_severity
+func (srv *Server) muted(a *Alert, now time.Time) bool {
+	config := srv.config()
+	if config.severityRank(a.Labels["severity"]) >= config.severityRank(config.MuteSeverity) {
+		return false
+	}
+	now = now.In(config.muteLocation)
+	for i := range config.muteWindows {
+		if config.muteWindows[i].contains(now) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Notifier delivers a payload to a chat backend
+type Notifier interface {
+	Notify(ctx context.Context, p *Payload) error
+}
+
+// AlertNotifier delivers an alert once, given the payloads built for its slack targets,
+// unlike a Notifier which is given each of them. a is nil for a message about no alert,
+// like the watchdog warning.
+type AlertNotifier interface {
+	NotifyAlert(ctx context.Context, a *Alert, payloads []Payload) error
+}
+
+// notifiers are the backends of a config
+type notifiers struct {
+	chat  Notifier        // slack, nil when not used
+	alert []AlertNotifier // backends given each alert once
+}
+
+// newNotifiers returns the notifiers of config, slack being used unless only other chats are configured
+func newNotifiers(config *Config) *notifiers {
+	if config.DryRun {
+		return &notifiers{chat: logNotifier{}}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 10 // keep connections to slack for concurrent sends
+	client := webhookClient{
+		Client:           &http.Client{Timeout: config.IntegrationTimeout, Transport: transport},
+		Retries:          config.SendRetries,
+		MaxResponseBytes: config.MaxResponseBytes,
+	}
+	named := func(name string) webhookClient {
+		c := client
+		c.Name = name
+		return c
+	}
+	slackClient := named("slack")
+	slackClient.Client = &http.Client{Timeout: config.SendTimeout, Transport: transport}
+	var ns []AlertNotifier
+	if config.TeamsWebhook != "" || len(config.teamsChannels) > 0 {
+		ns = append(ns, &TeamsNotifier{webhookClient: named("teams"), Webhook: config.TeamsWebhook, Channels: config.teamsChannels})
+	}
+	if config.DiscordWebhook != "" || len(config.discordChannels) > 0 {
+		ns = append(ns, &DiscordNotifier{webhookClient: named("discord"), Webhook: config.DiscordWebhook, Channels: config.discordChannels})
+	}
+	var slack Notifier
+	if config.SlackWebhook != "" || config.SlackBotToken != "" || len(config.webhooks) > 0 || len(ns) == 0 {
+		slack = &SlackNotifier{webhookClient: slackClient, Webhook: config.SlackWebhook, BotToken: config.SlackBotToken, Resolved: config.SlackResolved}
+	}
+	if config.PagerDutyKey != "" {
+		ns = append(ns, &PagerDutyNotifier{webhookClient: named("pagerduty"), RoutingKey: config.PagerDutyKey, Severity: config.PagerDutySeverity, config: config})
+	}
+	return &notifiers{chat: slack, alert: ns}
+}
+
+// webhookNotifier posts payloads to the webhook of their channel, like teams and discord
+type webhookNotifier interface {
+	Notify(ctx context.Context, p *Payload) error
+	webhook(channel string) string
+}
+
+// notifyWebhooks posts only the first of the payloads going to the same webhook, so that an alert
+// sent to several channels sharing a webhook is posted there once
+func notifyWebhooks(ctx context.Context, n webhookNotifier, payloads []Payload) error {
+	var failed error
+	seen := map[string]bool{}
+	for i := range payloads {
+		webhook := n.webhook(payloads[i].Channel)
+		if seen[webhook] {
+			continue
+		}
+		seen[webhook] = true
+		if err := n.Notify(ctx, &payloads[i]); err != nil {
+			failed = err
+		}
+	}
+	return failed
+}
+
+// plainText returns the text of p for chats other than slack, the silence command without the slack
+// escaping, or the text of a message without attachments like the watchdog warning
+func plainText(p *Payload) string {
+	if p.SilenceCmd != "" {
+		return "`" + p.SilenceCmd + "`"
+	}
+	return p.Text
+}
+
+// logNotifier only logs payloads, for dry_run
+type logNotifier struct{}
+
+func (logNotifier) Notify(ctx context.Context, p *Payload) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	slog.Info("dry run, would send", "channel", p.Channel, "payload", string(data))
+	return nil
+}
+
+// webhookClient posts json messages to incoming webhooks, retrying failed posts
+type webhookClient struct {
+	Name             string // of the notifier in metrics
+	Client           *http.Client
+	Retries          int
+	MaxResponseBytes int64 // max size of a decoded response, 0 for no limit
+}
+
+// send posts data to webhook, channel is only used for logging
+func (c *webhookClient) send(ctx context.Context, webhook, channel string, data []byte) error {
+	if err := c.call(ctx, webhook, "", channel, data, nil); err != nil {
+		return err
+	}
+	alertsSent.WithLabelValues(c.Name).Inc()
+	return nil
+}
+
+// call posts data to url with an optional bearer token, decoding the json response into result if not nil.
+// A failed post is counted in sendsFailed, a successful one is counted by the caller once it checked the response.
+func (c *webhookClient) call(ctx context.Context, url, token, channel string, data []byte, result interface{}) error {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		retry, wait, err := c.post(ctx, url, token, data, result)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= c.Retries {
+			sendsFailed.WithLabelValues(c.Name).Inc()
+			slog.Error("failed to send alert", "channel", channel, "payload", string(data), "err", err)
+			return err
+		}
+		if wait == 0 {
+			wait = backoff
+			backoff *= 2
+		}
+		slog.Warn("failed to send alert, retrying", "channel", channel, "err", err, "wait", wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			sendsFailed.WithLabelValues(c.Name).Inc()
+			return ctx.Err()
+		}
+	}
+}
+
+// webhookError is a non 2xx response of a webhook
+type webhookError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *webhookError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Status, e.Body)
+}
+
+// post posts data to a webhook once, on failure it reports whether the post
+// is worth retrying and how long the webhook asked to wait before that
+func (c *webhookClient) post(ctx context.Context, url, token string, data []byte, result interface{}) (retry bool, wait time.Duration, err error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(data))
+	if err != nil {
+		return false, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	start := time.Now()
+	resp, err := c.Client.Do(req)
+	sendDuration.WithLabelValues(c.Name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		return true, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		if result != nil {
+			body := resp.Body
+			if c.MaxResponseBytes > 0 {
+				body = &limitedReader{ReadCloser: resp.Body, left: c.MaxResponseBytes, limit: c.MaxResponseBytes}
+			}
+			if err = json.NewDecoder(body).Decode(result); err != nil {
+				return false, 0, fmt.Errorf("failed to decode response: %s", err)
+			}
+		}
+		return false, 0, nil
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = &webhookError{StatusCode: resp.StatusCode, Status: resp.Status, Body: string(body)}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return true, time.Duration(seconds) * time.Second, err
+	case resp.StatusCode >= 500:
+		return true, 0, err
+	}
+	return false, 0, err // malformed payload, retrying won't help
+}
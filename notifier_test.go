@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResponseSizeLimited(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok": true, "error": "` + strings.Repeat("x", 100) + `"}`))
+	}))
+	t.Cleanup(server.Close)
+	tests := []struct {
+		max     int64
+		wantErr bool
+	}{
+		{0, false}, // no limit
+		{1024, false},
+		{64, true},
+	}
+	for _, tt := range tests {
+		c := &webhookClient{Client: server.Client(), MaxResponseBytes: tt.max}
+		var resp slackResponse
+		err := c.call(context.Background(), server.URL, "token", "#ops", []byte("{}"), &resp)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("max %d: error = %v, want error %v", tt.max, err, tt.wantErr)
+		}
+		if err == nil && !resp.OK {
+			t.Errorf("max %d: response not decoded", tt.max)
+		}
+	}
+}
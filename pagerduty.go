@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+)
+
+const pagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+
+// PagerDutyNotifier triggers pagerduty incidents for alerts at or above a severity,
+// and resolves them when the alerts resolve
+type PagerDutyNotifier struct {
+	webhookClient
+	RoutingKey string
+	Severity   string  // min severity paged
+	config     *Config // ranks severities
+}
+
+type PagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"` // trigger or resolve
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *PagerDutyPayload `json:"payload,omitempty"`
+	Links       []PagerDutyLink   `json:"links,omitempty"`
+}
+
+type PagerDutyPayload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity"` // critical, error, warning or info
+	CustomDetails map[string]string `json:"custom_details,omitempty"`
+}
+
+type PagerDutyLink struct {
+	Href string `json:"href"`
+	Text string `json:"text,omitempty"`
+}
+
+// NotifyAlert pages the alert once whatever its slack targets, summarized by the title of its messages
+func (n *PagerDutyNotifier) NotifyAlert(ctx context.Context, a *Alert, payloads []Payload) error {
+	if a == nil || n.config.severityRank(a.Labels["severity"]) < n.config.severityRank(n.Severity) {
+		return nil
+	}
+	summary := a.Annotations["summary"]
+	if len(payloads) > 0 && len(payloads[0].Attachments) > 0 {
+		summary = payloads[0].Attachments[0].Title
+	}
+	if summary == "" {
+		summary = a.Labels["alertname"]
+	}
+	return n.notify(ctx, a, summary)
+}
+
+func (n *PagerDutyNotifier) notify(ctx context.Context, a *Alert, summary string) error {
+	e := PagerDutyEvent{
+		RoutingKey:  n.RoutingKey,
+		EventAction: "trigger",
+		DedupKey:    fmt.Sprintf("molert-%x", sha1.Sum([]byte(a.GeneratorURL))),
+	}
+	if a.resolved() {
+		e.EventAction = "resolve"
+	} else {
+		severity := a.Labels["severity"]
+		switch severity {
+		case "critical", "warning", "info":
+		default: // the severities pagerduty knows
+			severity = "error"
+		}
+		e.Payload = &PagerDutyPayload{
+			Summary:       summary,
+			Source:        a.GeneratorURL,
+			Severity:      severity,
+			CustomDetails: a.Labels,
+		}
+		e.Links = []PagerDutyLink{{Href: a.GeneratorURL, Text: "Source"}}
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		slog.Error("failed to marshal pagerduty event, alert would not sent", "event", e, "err", err)
+		return err
+	}
+	return n.send(ctx, pagerDutyURL, "pagerduty", data)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// reconcile removes the urls of alert_urls whose alert has expired, which getAlerts would only
+// remove once they are listed
+func (srv *Server) reconcile() {
+	urls, err := srv.alertURLs()
+	if err != nil {
+		slog.Error("failed to list alerts", "command", "SSCAN", "err", err)
+		return
+	}
+	if len(urls) == 0 {
+		return
+	}
+	cmds := make([]redisCmd, len(urls))
+	for i, url := range urls {
+		cmds[i] = redisCmd{"EXISTS", []interface{}{srv.alertKey(url)}}
+	}
+	stale := []interface{}{srv.key("alert_urls")}
+	for i, resp := range srv.redis.Pipe(cmds) {
+		if n, err := resp.Int(); err == nil && n == 0 {
+			stale = append(stale, urls[i])
+		}
+	}
+	if len(stale) == 1 {
+		slog.Debug("reconciled alert_urls, nothing to remove", "alerts", len(urls))
+		return
+	}
+	removed, err := srv.redis.Cmd("SREM", stale...).Int()
+	if err != nil {
+		slog.Warn("failed to remove expired alerts from alert_urls", "command", "SREM", "err", err)
+		return
+	}
+	slog.Info("removed expired alerts from alert_urls", "removed", removed, "alerts", len(urls))
+}
+
+// reconcileLoop runs reconcile every reconcile_interval until done is closed
+func (srv *Server) reconcileLoop(done chan struct{}) {
+	if srv.config().ReconcileInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(srv.config().ReconcileInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			srv.reconcile()
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/pool"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/mediocregopher/radix.v2/sentinel"
+)
+
+// redisConn is a redis connection pool retrying commands with exponential backoff
+// when the connection is lost, each command runs on its own pooled connection.
+type redisConn struct {
+	pool       connPool
+	retries    int
+	backoffMax time.Duration
+}
+
+// connPool hands out connections to redis, or to the master found by sentinel
+type connPool interface {
+	Get() (*redis.Client, error)
+	Put(*redis.Client)
+	Empty()
+}
+
+// redisSetupError is returned when redis rejects AUTH or SELECT, retrying won't help
+type redisSetupError struct {
+	cmd string
+	err error
+}
+
+func (e *redisSetupError) Error() string {
+	return fmt.Sprintf("redis rejected %s: %s", e.cmd, e.err)
+}
+
+// redisUnavailableError is the error of a command that failed with an I/O error on every retry
+type redisUnavailableError struct {
+	err error
+}
+
+func (e *redisUnavailableError) Error() string {
+	return "redis unavailable: " + e.err.Error()
+}
+
+func (e *redisUnavailableError) Unwrap() error {
+	return e.err
+}
+
+// newRedisConn connects to redis, a connection failure is only logged since connections
+// are dialed again on demand, but an error is returned if redis rejects the password or db
+func newRedisConn(config *Config) (*redisConn, error) {
+	dial := func(network, addr string) (*redis.Client, error) {
+		client, err := redis.Dial(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if config.RedisPassword != "" {
+			if err := setup(client, "AUTH", config.RedisPassword); err != nil {
+				return nil, err
+			}
+		}
+		if config.RedisDB != 0 {
+			if err := setup(client, "SELECT", config.RedisDB); err != nil {
+				return nil, err
+			}
+		}
+		return client, nil
+	}
+	c := &redisConn{retries: config.RedisRetries, backoffMax: config.RedisBackoffMax}
+	if config.RedisSentinel != "" {
+		sp := &sentinelPool{
+			addrs: strings.Split(config.RedisSentinel, ","),
+			name:  config.RedisMasterName,
+			size:  config.RedisPoolSize,
+			dial:  dial,
+		}
+		if err := sp.connect(); err != nil {
+			if _, ok := err.(*redisSetupError); ok {
+				return nil, err
+			}
+			slog.Warn("failed to connect redis sentinel, will retry on first command", "sentinel", config.RedisSentinel, "err", err)
+		}
+		c.pool = sp
+		return c, nil
+	}
+	p, err := pool.NewCustom("tcp", config.RedisURL, config.RedisPoolSize, dial)
+	if err != nil {
+		if _, ok := err.(*redisSetupError); ok {
+			return nil, err
+		}
+		slog.Warn("failed to connect redis, will retry on first command", "addr", config.RedisURL, "err", err)
+	}
+	c.pool = p
+	return c, nil
+}
+
+// sentinelPool hands out connections to the current master of name, following failovers.
+// When the sentinel is lost, it connects to the next sentinel of addrs.
+type sentinelPool struct {
+	addrs []string
+	name  string
+	size  int
+	dial  sentinel.DialFunc
+
+	mu     sync.Mutex
+	client *sentinel.Client
+	next   int // index in addrs of the sentinel to try next
+}
+
+// connect connects to the first reachable sentinel, starting with the next one
+func (sp *sentinelPool) connect() error {
+	var err error
+	for i := 0; i < len(sp.addrs); i++ {
+		addr := strings.TrimSpace(sp.addrs[sp.next])
+		sp.next = (sp.next + 1) % len(sp.addrs)
+		var client *sentinel.Client
+		if client, err = sentinel.NewClientCustom("tcp", addr, sp.size, sp.dial, sp.name); err == nil {
+			sp.client = client
+			return nil
+		}
+		slog.Warn("failed to connect redis sentinel", "sentinel", addr, "err", err)
+	}
+	return err
+}
+
+func (sp *sentinelPool) Get() (*redis.Client, error) {
+	sp.mu.Lock()
+	client := sp.client
+	sp.mu.Unlock()
+	if client != nil {
+		conn, err := client.GetMaster(sp.name)
+		if err == nil {
+			return conn, nil
+		}
+		if ce, ok := err.(*sentinel.ClientError); !ok || !ce.SentinelErr {
+			return nil, err // master unreachable, sentinel will fail it over
+		}
+	}
+	// sentinel is lost, reconnect once for all callers. The lost client is not closed,
+	// a concurrent GetMaster on a closed client would block forever
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.client == client {
+		if err := sp.connect(); err != nil {
+			return nil, err
+		}
+	}
+	if sp.client == nil {
+		return nil, errors.New("no redis sentinel reachable")
+	}
+	return sp.client.GetMaster(sp.name)
+}
+
+func (sp *sentinelPool) Put(conn *redis.Client) {
+	sp.mu.Lock()
+	client := sp.client
+	sp.mu.Unlock()
+	if client == nil {
+		conn.Close()
+		return
+	}
+	client.PutMaster(sp.name, conn)
+}
+
+func (sp *sentinelPool) Empty() {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.client != nil {
+		sp.client.Close()
+		sp.client = nil
+	}
+}
+
+// setup runs AUTH or SELECT on a new connection, closing it on failure
+func setup(client *redis.Client, cmd string, arg interface{}) error {
+	resp := client.Cmd(cmd, arg)
+	if resp.Err == nil {
+		return nil
+	}
+	client.Close()
+	if resp.IsType(redis.AppErr) {
+		return &redisSetupError{cmd: cmd, err: resp.Err}
+	}
+	return resp.Err
+}
+
+type redisCmd struct {
+	cmd  string
+	args []interface{}
+}
+
+// Cmd runs a redis command, retrying it on a new connection after an I/O error
+func (c *redisConn) Cmd(cmd string, args ...interface{}) *redis.Resp {
+	var resp *redis.Resp
+	c.retry(cmd, func(client *redis.Client) *redis.Resp {
+		resp = client.Cmd(cmd, args...)
+		return resp
+	}, func(err *redis.Resp) {
+		resp = err
+	})
+	return resp
+}
+
+// Pipe runs commands in one round-trip on a single connection, retrying all of them after an I/O error
+func (c *redisConn) Pipe(cmds []redisCmd) []*redis.Resp {
+	resps := make([]*redis.Resp, len(cmds))
+	c.retry("pipeline", func(client *redis.Client) *redis.Resp {
+		for _, cmd := range cmds {
+			client.PipeAppend(cmd.cmd, cmd.args...)
+		}
+		var ioErr *redis.Resp
+		for i := range cmds {
+			resps[i] = client.PipeResp()
+			if ioErr == nil && resps[i].IsType(redis.IOErr) {
+				ioErr = resps[i]
+			}
+		}
+		return ioErr
+	}, func(err *redis.Resp) {
+		for i := range resps {
+			resps[i] = err
+		}
+	})
+	return resps
+}
+
+// retry calls do with a pooled connection until it returns no I/O error or retries are exhausted,
+// failed is called when no connection can be made. The error of the last attempt is then a *redisUnavailableError.
+func (c *redisConn) retry(name string, do func(*redis.Client) *redis.Resp, failed func(*redis.Resp)) {
+	backoff := 100 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		var ioErr *redis.Resp
+		client, err := c.pool.Get()
+		if err != nil {
+			ioErr = redis.NewRespIOErr(err)
+			failed(ioErr)
+		} else {
+			if resp := do(client); resp != nil && resp.IsType(redis.IOErr) {
+				ioErr = resp
+			}
+			c.pool.Put(client) // dropped by the pool after an I/O error
+		}
+		if ioErr == nil {
+			return
+		}
+		if attempt >= c.retries {
+			ioErr.Err = &redisUnavailableError{err: ioErr.Err}
+			return
+		}
+		slog.Warn("redis command failed, reconnecting", "command", name, "err", ioErr.Err, "backoff", backoff, "attempt", attempt+1, "retries", c.retries)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > c.backoffMax {
+			backoff = c.backoffMax
+		}
+	}
+}
+
+func (c *redisConn) Close() error {
+	c.pool.Empty()
+	return nil
+}
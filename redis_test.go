@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// fakeRedis is an in-memory redis server speaking enough of the protocol for molert
+type fakeRedis struct {
+	mu      sync.Mutex
+	strings map[string]string
+	hashes  map[string]map[string]string
+	sets    map[string]map[string]bool
+	lists   map[string][]string
+	zsets   map[string]map[string]float64
+	expires map[string]time.Time
+	reads   int64                           // reads off the connections, one per round-trip of a client
+	before  func(cmd string, args []string) // run before each command if set
+}
+
+// startFakeRedis serves a fakeRedis until the test ends and returns its address
+func startFakeRedis(t testing.TB) (*fakeRedis, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	fr := &fakeRedis{
+		strings: map[string]string{},
+		hashes:  map[string]map[string]string{},
+		sets:    map[string]map[string]bool{},
+		lists:   map[string][]string{},
+		zsets:   map[string]map[string]float64{},
+		expires: map[string]time.Time{},
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go fr.serve(conn)
+		}
+	}()
+	return fr, l.Addr().String()
+}
+
+func (fr *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	rr := redis.NewRespReader(&countingReader{conn, &fr.reads})
+	for {
+		req := rr.Read()
+		if req.IsType(redis.IOErr) {
+			return
+		}
+		args, err := req.List()
+		if err != nil || len(args) == 0 {
+			redis.NewResp(errors.New("ERR bad request")).WriteTo(conn)
+			continue
+		}
+		fr.mu.Lock()
+		if fr.before != nil {
+			fr.before(strings.ToUpper(args[0]), args[1:])
+		}
+		resp := fr.do(strings.ToUpper(args[0]), args[1:])
+		fr.mu.Unlock()
+		if _, err := resp.WriteTo(conn); err != nil {
+			return
+		}
+	}
+}
+
+// countingReader counts the reads returning data
+type countingReader struct {
+	io.Reader
+	n *int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		atomic.AddInt64(r.n, 1)
+	}
+	return n, err
+}
+
+// exists reports whether key is set and not expired, deleting it when expired
+func (fr *fakeRedis) exists(key string) bool {
+	if at, found := fr.expires[key]; found && !time.Now().Before(at) {
+		fr.del(key)
+	}
+	_, s := fr.strings[key]
+	_, h := fr.hashes[key]
+	_, st := fr.sets[key]
+	_, l := fr.lists[key]
+	_, z := fr.zsets[key]
+	return s || h || st || l || z
+}
+
+func (fr *fakeRedis) del(key string) {
+	delete(fr.strings, key)
+	delete(fr.hashes, key)
+	delete(fr.sets, key)
+	delete(fr.lists, key)
+	delete(fr.zsets, key)
+	delete(fr.expires, key)
+}
+
+// index returns the list index i counting from the end when negative, clamped to the list
+func index(i, n int) int {
+	if i < 0 {
+		i += n
+	}
+	if i < 0 {
+		return 0
+	}
+	if i > n {
+		return n
+	}
+	return i
+}
+
+func (fr *fakeRedis) do(cmd string, args []string) *redis.Resp {
+	if cmd != "PING" && len(args) == 0 {
+		return redis.NewResp(errors.New("ERR wrong number of arguments"))
+	}
+	var key string
+	if len(args) > 0 {
+		key = args[0]
+		fr.exists(key) // drop it when expired
+	}
+	switch cmd {
+	case "PING":
+		return redis.NewRespSimple("PONG")
+	case "SET":
+		fr.del(key)
+		fr.strings[key] = args[1]
+		if len(args) == 4 && strings.ToUpper(args[2]) == "EX" {
+			seconds, _ := strconv.Atoi(args[3])
+			fr.expires[key] = time.Now().Add(time.Duration(seconds) * time.Second)
+		}
+		return redis.NewRespSimple("OK")
+	case "GET":
+		if v, found := fr.strings[key]; found {
+			return redis.NewResp(v)
+		}
+		return redis.NewResp(nil)
+	case "DEL":
+		n := 0
+		for _, k := range args {
+			if fr.exists(k) {
+				fr.del(k)
+				n++
+			}
+		}
+		return redis.NewResp(n)
+	case "EXISTS":
+		if fr.exists(key) {
+			return redis.NewResp(1)
+		}
+		return redis.NewResp(0)
+	case "EXPIRE":
+		if !fr.exists(key) {
+			return redis.NewResp(0)
+		}
+		seconds, _ := strconv.Atoi(args[1])
+		fr.expires[key] = time.Now().Add(time.Duration(seconds) * time.Second)
+		return redis.NewResp(1)
+	case "PERSIST":
+		if _, found := fr.expires[key]; !found {
+			return redis.NewResp(0)
+		}
+		delete(fr.expires, key)
+		return redis.NewResp(1)
+	case "TTL":
+		if !fr.exists(key) {
+			return redis.NewResp(-2)
+		}
+		at, found := fr.expires[key]
+		if !found {
+			return redis.NewResp(-1)
+		}
+		return redis.NewResp(int(time.Until(at).Round(time.Second) / time.Second))
+	case "HSET":
+		h := fr.hashes[key]
+		if h == nil {
+			h = map[string]string{}
+			fr.hashes[key] = h
+		}
+		n := 0
+		for i := 1; i+1 < len(args); i += 2 {
+			if _, found := h[args[i]]; !found {
+				n++
+			}
+			h[args[i]] = args[i+1]
+		}
+		return redis.NewResp(n)
+	case "HGET":
+		if v, found := fr.hashes[key][args[1]]; found {
+			return redis.NewResp(v)
+		}
+		return redis.NewResp(nil)
+	case "HMGET":
+		values := make([]interface{}, len(args)-1)
+		for i, field := range args[1:] {
+			if v, found := fr.hashes[key][field]; found {
+				values[i] = v
+			}
+		}
+		return redis.NewResp(values)
+	case "HEXISTS":
+		if _, found := fr.hashes[key][args[1]]; found {
+			return redis.NewResp(1)
+		}
+		return redis.NewResp(0)
+	case "HDEL":
+		n := 0
+		for _, field := range args[1:] {
+			if _, found := fr.hashes[key][field]; found {
+				delete(fr.hashes[key], field)
+				n++
+			}
+		}
+		if len(fr.hashes[key]) == 0 {
+			fr.del(key)
+		}
+		return redis.NewResp(n)
+	case "SADD":
+		s := fr.sets[key]
+		if s == nil {
+			s = map[string]bool{}
+			fr.sets[key] = s
+		}
+		n := 0
+		for _, m := range args[1:] {
+			if !s[m] {
+				s[m] = true
+				n++
+			}
+		}
+		return redis.NewResp(n)
+	case "SREM":
+		n := 0
+		for _, m := range args[1:] {
+			if fr.sets[key][m] {
+				delete(fr.sets[key], m)
+				n++
+			}
+		}
+		if len(fr.sets[key]) == 0 {
+			fr.del(key)
+		}
+		return redis.NewResp(n)
+	case "SCARD":
+		return redis.NewResp(len(fr.sets[key]))
+	case "SSCAN":
+		members := []interface{}{}
+		for m := range fr.sets[key] {
+			members = append(members, m)
+		}
+		return redis.NewResp([]interface{}{"0", members})
+	case "LPUSH":
+		for _, v := range args[1:] {
+			fr.lists[key] = append([]string{v}, fr.lists[key]...)
+		}
+		return redis.NewResp(len(fr.lists[key]))
+	case "LTRIM":
+		l := fr.lists[key]
+		start, _ := strconv.Atoi(args[1])
+		stop, _ := strconv.Atoi(args[2])
+		start, stop = index(start, len(l)), index(stop, len(l))+1
+		if stop > len(l) {
+			stop = len(l)
+		}
+		if start >= stop {
+			fr.del(key)
+		} else {
+			fr.lists[key] = l[start:stop]
+		}
+		return redis.NewRespSimple("OK")
+	case "LINDEX":
+		l := fr.lists[key]
+		i, _ := strconv.Atoi(args[1])
+		if i < 0 {
+			i += len(l)
+		}
+		if i < 0 || i >= len(l) {
+			return redis.NewResp(nil)
+		}
+		return redis.NewResp(l[i])
+	case "LRANGE":
+		l := fr.lists[key]
+		start, _ := strconv.Atoi(args[1])
+		stop, _ := strconv.Atoi(args[2])
+		start, stop = index(start, len(l)), index(stop, len(l))+1
+		if stop > len(l) {
+			stop = len(l)
+		}
+		values := []interface{}{}
+		for i := start; i < stop; i++ {
+			values = append(values, l[i])
+		}
+		return redis.NewResp(values)
+	case "ZADD":
+		z := fr.zsets[key]
+		if z == nil {
+			z = map[string]float64{}
+			fr.zsets[key] = z
+		}
+		score, _ := strconv.ParseFloat(args[1], 64)
+		_, found := z[args[2]]
+		z[args[2]] = score
+		if found {
+			return redis.NewResp(0)
+		}
+		return redis.NewResp(1)
+	case "ZREM":
+		if _, found := fr.zsets[key][args[1]]; !found {
+			return redis.NewResp(0)
+		}
+		delete(fr.zsets[key], args[1])
+		return redis.NewResp(1)
+	case "ZRANGE", "ZRANGEBYSCORE":
+		min, max := -1e300, 1e300
+		if cmd == "ZRANGEBYSCORE" && args[2] != "+inf" {
+			max, _ = strconv.ParseFloat(args[2], 64)
+		}
+		var members []string
+		for m, score := range fr.zsets[key] {
+			if score >= min && score <= max {
+				members = append(members, m)
+			}
+		}
+		sort.Slice(members, func(i, j int) bool {
+			return fr.zsets[key][members[i]] < fr.zsets[key][members[j]]
+		})
+		values := []interface{}{}
+		for _, m := range members {
+			values = append(values, m)
+		}
+		return redis.NewResp(values)
+	}
+	return redis.NewResp(errors.New("ERR unknown command " + cmd))
+}
+
+// testNotifier records the payloads it is given, failing them while err is set
+type testNotifier struct {
+	mu       sync.Mutex
+	payloads []*Payload
+	err      error
+}
+
+func (n *testNotifier) Notify(ctx context.Context, p *Payload) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.err != nil {
+		return n.err
+	}
+	n.payloads = append(n.payloads, p)
+	return nil
+}
+
+// sent returns the alert names of the payloads sent so far
+func (n *testNotifier) sent() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var names []string
+	for _, p := range n.payloads {
+		for _, a := range p.Alerts {
+			names = append(names, a.Labels["alertname"])
+		}
+	}
+	return names
+}
+
+// newTestServer returns a server on a fake redis configured with args
+func newTestServer(t testing.TB, args ...string) (*Server, *testNotifier) {
+	_, addr := startFakeRedis(t)
+	return newTestServerOn(t, addr, args...)
+}
+
+// newTestServerOn returns a server on the redis at addr configured with args
+func newTestServerOn(t testing.TB, addr string, args ...string) (*Server, *testNotifier) {
+	config, err := loadConfig(append([]string{"-redis_url=" + addr, "-log_level=error"}, args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := newServer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.redis.Close() })
+	notifier := &testNotifier{}
+	srv.notifiers.Store(&notifiers{chat: notifier})
+	return srv, notifier
+}
+
+// testAlertNotifier records the alerts it is given
+type testAlertNotifier struct {
+	mu     sync.Mutex
+	alerts []string
+}
+
+func (n *testAlertNotifier) NotifyAlert(ctx context.Context, a *Alert, payloads []Payload) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.alerts = append(n.alerts, a.Labels["alertname"])
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// restartFlags only take effect on restart, reload keeps their running value
+var restartFlags = []string{
+	"redis_url", "redis_password", "redis_db", "redis_pool_size", "redis_retries", "redis_backoff_max",
+	"redis_sentinel", "redis_master_name", "listen_addr", "tls_cert", "tls_key", "access_log",
+	"cors_origins", "reconcile_interval",
+}
+
+// setConfig makes config the running config, with its notifiers and logger
+func (srv *Server) setConfig(config *Config) {
+	slog.SetDefault(config.logger)
+	srv.notifiers.Store(newNotifiers(config))
+	srv.cfg.Store(config)
+}
+
+// reload loads the command line and the -config file with the files they name again, and swaps
+// the result in once it is valid. On error, the running config is kept.
+func (srv *Server) reload() error {
+	running := srv.config()
+	config, err := loadConfig(running.args)
+	if err != nil {
+		return err
+	}
+	for _, name := range restartFlags {
+		value := running.flags.Lookup(name).Value.String()
+		if config.flags.Lookup(name).Value.String() == value {
+			continue
+		}
+		slog.Warn("config changed, restart molert to apply it", "flag", name)
+		if err = config.flags.Set(name, value); err != nil {
+			return err
+		}
+	}
+	srv.setConfig(config)
+	slog.Info("config reloaded", "config", config.File)
+	return nil
+}
+
+// reloadHandler reloads the config like SIGHUP
+func (srv *Server) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if err := srv.reload(); err != nil {
+		slog.Error("failed to reload config, keeping the running one", "err", err)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
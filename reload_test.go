@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "molert.json")
+	write := func(config string) {
+		if err := os.WriteFile(file, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"default_channel": "#alerts"}`)
+	srv, _ := newTestServer(t, "-config="+file)
+	listenAddr := srv.config().ListenAddr
+
+	write(`{"default_channel": "#ops", "listen_addr": "127.0.0.1:1"}`)
+	w := httptest.NewRecorder()
+	srv.mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/reload", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("reload answered %d: %s", w.Code, w.Body)
+	}
+	if got := srv.config().DefaultChannel; got != "#ops" {
+		t.Errorf("default_channel = %q after reload, want #ops", got)
+	}
+	if got := srv.config().ListenAddr; got != listenAddr {
+		t.Errorf("listen_addr = %q after reload, want the running %q", got, listenAddr)
+	}
+
+	for _, config := range []string{`{"default_channel": `, `{"min_severity": "major"}`, `{"unknown": true}`} {
+		write(config)
+		if err := srv.reload(); err == nil {
+			t.Errorf("reload of %s succeeded", config)
+		}
+		if got := srv.config().DefaultChannel; got != "#ops" {
+			t.Errorf("default_channel = %q after failed reload, want the running #ops", got)
+		}
+	}
+}
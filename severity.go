@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseSeverityLevels parses comma separated severities from lowest to highest, eg. "info,warning,critical"
+func parseSeverityLevels(s string) (map[string]int, error) {
+	ranks := map[string]int{}
+	for _, severity := range strings.Split(s, ",") {
+		severity = strings.TrimSpace(severity)
+		if severity == "" {
+			continue
+		}
+		if _, found := ranks[severity]; found {
+			return nil, fmt.Errorf("duplicate severity %q in severity levels", severity)
+		}
+		ranks[severity] = len(ranks) + 1
+	}
+	if len(ranks) == 0 {
+		return nil, fmt.Errorf("no severity in severity levels %q", s)
+	}
+	return ranks, nil
+}
+
+// severityRank orders severities by the severity_levels of c, unknown or missing severity is the lowest
+func (c *Config) severityRank(severity string) int {
+	return c.severityRanks[severity]
+}
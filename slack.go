@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const slackAPI = "https://slack.com/api/"
+
+// SlackNotifier posts payloads to slack incoming webhooks, or with the web api when it has a bot token
+type SlackNotifier struct {
+	webhookClient
+	Webhook  string // used for payloads without their own webhook
+	BotToken string
+	Resolved string // what happens to the message of a resolved alert: update, delete or post
+}
+
+// slackResponse is the response of chat.postMessage and chat.update
+type slackResponse struct {
+	OK      bool   `json:"ok"`
+	Error   string `json:"error"`
+	Channel string `json:"channel"`
+	TS      string `json:"ts"`
+}
+
+func (n *SlackNotifier) Notify(ctx context.Context, p *Payload) error {
+	if n.BotToken != "" {
+		return n.notifyAPI(ctx, p)
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		slog.Error("failed to marshal payload, alert would not sent", "payload", p, "err", err)
+		return err
+	}
+	webhook := p.Webhook
+	if webhook == "" {
+		webhook = n.Webhook
+	}
+	return n.send(ctx, webhook, p.Channel, data)
+}
+
+// notifyAPI updates the message of p.MessageTS with chat.update, or posts a new message with
+// chat.postMessage and records its channel id and ts in p
+func (n *SlackNotifier) notifyAPI(ctx context.Context, p *Payload) error {
+	resolved := len(p.Alerts) == 1 && p.Alerts[0].resolved()
+	if resolved && n.Resolved == "delete" && p.MessageTS != "" && p.ChannelID != "" {
+		data, _ := json.Marshal(map[string]string{"channel": p.ChannelID, "ts": p.MessageTS})
+		return n.callMethod(ctx, "chat.delete", p.Channel, data)
+	}
+	if resolved && n.Resolved == "post" {
+		p.MessageTS = ""
+	}
+	if p.MessageTS != "" && p.ChannelID != "" {
+		update := *p
+		update.Channel = p.ChannelID
+		_, err := n.callAPI(ctx, "chat.update", &update)
+		if err == nil {
+			return nil
+		}
+		if se, ok := err.(*webhookError); !ok || se.Body != "message_not_found" {
+			return err
+		}
+		slog.Info("slack message to update is gone, posting a new one", "channel", p.Channel, "ts", p.MessageTS)
+	}
+	post := *p
+	post.MessageTS = ""
+	resp, err := n.callAPI(ctx, "chat.postMessage", &post)
+	if err != nil {
+		return err
+	}
+	p.ChannelID, p.MessageTS = resp.Channel, resp.TS
+	return nil
+}
+
+// callAPI posts the payload to a slack web api method
+func (n *SlackNotifier) callAPI(ctx context.Context, method string, p *Payload) (*slackResponse, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		slog.Error("failed to marshal payload, alert would not sent", "payload", p, "err", err)
+		return nil, err
+	}
+	return n.callMethodResponse(ctx, method, p.Channel, data)
+}
+
+// callMethod posts data to a slack web api method, channel is only used for logging
+func (n *SlackNotifier) callMethod(ctx context.Context, method, channel string, data []byte) error {
+	_, err := n.callMethodResponse(ctx, method, channel, data)
+	return err
+}
+
+func (n *SlackNotifier) callMethodResponse(ctx context.Context, method, channel string, data []byte) (*slackResponse, error) {
+	var resp slackResponse
+	if err := n.call(ctx, slackAPI+method, n.BotToken, channel, data, &resp); err != nil {
+		return nil, err
+	}
+	if !resp.OK {
+		sendsFailed.WithLabelValues(n.Name).Inc()
+		err := &webhookError{StatusCode: http.StatusOK, Status: method + " failed", Body: resp.Error}
+		slog.Error("failed to send alert", "channel", channel, "method", method, "err", err)
+		return nil, err
+	}
+	alertsSent.WithLabelValues(n.Name).Inc()
+	return &resp, nil
+}
+
+// errWebhookRejected is returned by verifyWebhook when slack rejects the webhook url itself
+var errWebhookRejected = errors.New("slack rejected the webhook")
+
+// verifyWebhook posts an empty message to a slack webhook, which slack answers with 400 no_text
+// without posting anything if the webhook exists
+func (c *webhookClient) verifyWebhook(ctx context.Context, webhook string) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", webhook, strings.NewReader("{}"))
+	if err != nil {
+		return fmt.Errorf("%w: %s", errWebhookRejected, err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	switch {
+	case resp.StatusCode < 300 || (resp.StatusCode == http.StatusBadRequest && string(body) == "no_text"):
+		return nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return &webhookError{StatusCode: resp.StatusCode, Status: resp.Status, Body: string(body)}
+	}
+	return fmt.Errorf("%w: %s: %s", errWebhookRejected, resp.Status, body)
+}
+
+// verifyWebhooks checks slack_webhook and the webhooks of webhook_map at startup, exiting if slack
+// rejects one, while an unreachable slack is only logged
+func (srv *Server) verifyWebhooks() {
+	webhooks := map[string]string{}
+	if srv.config().SlackWebhook != "" {
+		webhooks["slack_webhook"] = srv.config().SlackWebhook
+	}
+	for value, webhook := range srv.config().webhooks {
+		webhooks["webhook_map "+value] = webhook
+	}
+	client := webhookClient{Client: &http.Client{Timeout: srv.config().SendTimeout}}
+	for name, webhook := range webhooks {
+		err := client.verifyWebhook(context.Background(), webhook)
+		switch {
+		case err == nil:
+			slog.Info("slack webhook verified", "webhook", name)
+		case errors.Is(err, errWebhookRejected):
+			log.Fatalf("invalid %s %s: %s", name, mask(webhook), err)
+		default:
+			slog.Warn("failed to verify slack webhook", "webhook", name, "err", err)
+		}
+	}
+}
+
+// messageField is the field of the alert hash keeping the channel id and ts of the message sent to channel
+func messageField(channel string) string {
+	return "slack_ts:" + channel
+}
+
+// loadMessages sets the channel id and ts of the slack messages already sent for the payloads,
+// so they are updated instead of posted again
+func (srv *Server) loadMessages(batches []*batch) {
+	var cmds []redisCmd
+	var payloads []*Payload
+	for _, b := range batches {
+		for i := range b.payloads {
+			p := &b.payloads[i]
+			cmds = append(cmds, redisCmd{"HGET", []interface{}{srv.alertKey(b.alert.Alert.GeneratorURL), messageField(p.Channel)}})
+			payloads = append(payloads, p)
+		}
+	}
+	if len(cmds) == 0 {
+		return
+	}
+	for i, resp := range srv.redis.Pipe(cmds) {
+		value, err := resp.Str()
+		if err != nil { // nil reply, no message sent yet
+			continue
+		}
+		if fields := strings.Fields(value); len(fields) == 2 {
+			payloads[i].ChannelID, payloads[i].MessageTS = fields[0], fields[1]
+		}
+	}
+}
+
+// saveMessages records the channel id and ts of the slack messages sent for the alert of b
+func (srv *Server) saveMessages(b *batch) {
+	url := b.alert.Alert.GeneratorURL
+	if n, err := srv.redis.Cmd("EXISTS", srv.alertKey(url)).Int(); err != nil || n == 0 {
+		return // expired meanwhile, don't recreate it without expiration
+	}
+	for _, p := range b.payloads {
+		if p.MessageTS == "" {
+			continue
+		}
+		if resp := srv.redis.Cmd("HSET", srv.alertKey(url), messageField(p.Channel), p.ChannelID+" "+p.MessageTS); resp.Err != nil {
+			slog.Warn("failed to save slack message ts", "url", url, "command", "HSET", "err", resp.Err)
+		}
+	}
+}
+
+// slackAction is the part of the interactive message payload slack posts on a button click
+type slackAction struct {
+	CallbackID string `json:"callback_id"`
+	Actions    []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"actions"`
+	User struct {
+		Name string `json:"name"`
+	} `json:"user"`
+}
+
+// slackActionHandler silences the alert of a clicked silence button
+func (srv *Server) slackActionHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := srv.readBody(w, r)
+	if err != nil {
+		slog.Warn("failed to read request", "err", err)
+		writeError(w, bodyErrorStatus(err), err)
+		return
+	}
+	defer r.Body.Close()
+	if !verifySlackSignature(srv.config().SlackSigningSecret, r.Header, body) {
+		slog.Warn("invalid slack signature, the action will be ignored", "remote", r.RemoteAddr)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var action slackAction
+	if err = json.Unmarshal([]byte(form.Get("payload")), &action); err != nil {
+		slog.Warn("failed to unmarshal incoming slack action", "body", form.Get("payload"), "err", err)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if action.CallbackID != "silence" || len(action.Actions) == 0 || action.Actions[0].Name != "silence" {
+		writeError(w, http.StatusBadRequest, errors.New("unknown action"))
+		return
+	}
+	s := Silence{URL: action.Actions[0].Value, CreatedBy: action.User.Name}
+	if err = srv.silence(&s); err != nil {
+		slog.Error("failed to silence alert", "url", s.URL, "err", err)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"response_type": "ephemeral", "replace_original": false, "text": "Failed to silence: " + err.Error()})
+		return
+	}
+	text := fmt.Sprintf("Silenced for %s by %s", humanizeDuration(time.Duration(srv.config().SilenceDuration)*time.Second), s.CreatedBy)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"response_type": "in_channel", "replace_original": false, "text": text})
+}
+
+// verifySlackSignature checks the X-Slack-Signature of a request signed with secret,
+// requests older than 5 minutes are rejected against replays
+func verifySlackSignature(secret string, header http.Header, body []byte) bool {
+	if secret == "" {
+		return false
+	}
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := time.Now().Unix() - ts; age > 5*60 || age < -5*60 {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:%s", timestamp, body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(header.Get("X-Slack-Signature")))
+}
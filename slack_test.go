@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSlackNotifier(t *testing.T) {
+	var mu sync.Mutex
+	posts := map[string]int{}
+	var channels []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p Payload
+		json.NewDecoder(r.Body).Decode(&p)
+		mu.Lock()
+		posts[r.URL.Path]++
+		channels = append(channels, p.Channel)
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/down":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/invalid":
+			http.Error(w, "invalid_payload", http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(server.Close)
+	n := &SlackNotifier{
+		webhookClient: webhookClient{Client: server.Client(), Retries: 1},
+		Webhook:       server.URL + "/default",
+	}
+	tests := []struct {
+		webhook string // of the payload
+		path    string
+		posts   int
+		wantErr bool
+	}{
+		{"", "/default", 1, false},
+		{server.URL + "/ops", "/ops", 1, false},
+		{server.URL + "/down", "/down", 2, true}, // retried
+		{server.URL + "/invalid", "/invalid", 1, true},
+	}
+	for _, tt := range tests {
+		mu.Lock()
+		posts, channels = map[string]int{}, nil
+		mu.Unlock()
+		err := n.Notify(context.Background(), &Payload{Channel: "#ops", Text: "ServiceDown", Webhook: tt.webhook})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.path, err, tt.wantErr)
+		}
+		mu.Lock()
+		if posts[tt.path] != tt.posts || len(posts) != 1 || channels[0] != "#ops" {
+			t.Errorf("%s: posts = %v to %v, want %d", tt.path, posts, channels, tt.posts)
+		}
+		mu.Unlock()
+	}
+}
+
+// rewriteTransport sends every request to server, whatever its url
+type rewriteTransport struct {
+	server *httptest.Server
+}
+
+func (t rewriteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	u, _ := url.Parse(t.server.URL)
+	r.URL.Scheme, r.URL.Host = u.Scheme, u.Host
+	return t.server.Client().Transport.RoundTrip(r)
+}
+
+func TestSlackAPIMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/chat.update" {
+			w.Write([]byte(`{"ok": false, "error": "cant_update_message"}`))
+			return
+		}
+		w.Write([]byte(`{"ok": true, "channel": "C024BE91L", "ts": "1.2"}`))
+	}))
+	t.Cleanup(server.Close)
+	n := &SlackNotifier{
+		webhookClient: webhookClient{Name: "slack_api_test", Client: &http.Client{Transport: rewriteTransport{server}}},
+		BotToken:      "token",
+	}
+	tests := []struct {
+		ts           string // of the message to update
+		sent, failed float64
+	}{
+		{"", 1, 0},
+		{"1.2", 0, 1}, // chat.update answers ok: false
+	}
+	for _, tt := range tests {
+		sent := testutil.ToFloat64(alertsSent.WithLabelValues(n.Name))
+		failed := testutil.ToFloat64(sendsFailed.WithLabelValues(n.Name))
+		n.Notify(context.Background(), &Payload{Channel: "#ops", Text: "ServiceDown", MessageTS: tt.ts, ChannelID: "C024BE91L"})
+		if got := testutil.ToFloat64(alertsSent.WithLabelValues(n.Name)) - sent; got != tt.sent {
+			t.Errorf("ts %q: sent %g, want %g", tt.ts, got, tt.sent)
+		}
+		if got := testutil.ToFloat64(sendsFailed.WithLabelValues(n.Name)) - failed; got != tt.failed {
+			t.Errorf("ts %q: failed %g, want %g", tt.ts, got, tt.failed)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+)
+
+// TeamsNotifier posts payloads as MessageCards to microsoft teams incoming webhooks
+type TeamsNotifier struct {
+	webhookClient
+	Webhook  string            // used for channels without their own webhook
+	Channels map[string]string // channel name to webhook
+}
+
+type MessageCard struct {
+	Type       string    `json:"@type"`
+	Context    string    `json:"@context"`
+	ThemeColor string    `json:"themeColor,omitempty"`
+	Summary    string    `json:"summary"`
+	Title      string    `json:"title,omitempty"`
+	Text       string    `json:"text,omitempty"`
+	Sections   []Section `json:"sections,omitempty"`
+	Actions    []OpenURI `json:"potentialAction,omitempty"`
+}
+
+type OpenURI struct {
+	Type    string   `json:"@type"`
+	Name    string   `json:"name"`
+	Targets []Target `json:"targets"`
+}
+
+type Target struct {
+	OS  string `json:"os"`
+	URI string `json:"uri"`
+}
+
+type Section struct {
+	ActivityTitle string `json:"activityTitle,omitempty"`
+	Text          string `json:"text,omitempty"`
+	Facts         []Fact `json:"facts,omitempty"`
+}
+
+type Fact struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// teamsColors maps slack attachment colors to hex colors, also used for discord
+var teamsColors = map[string]string{
+	"danger":  "D32F2F",
+	"warning": "FFA000",
+	"good":    "2E7D32",
+}
+
+func (n *TeamsNotifier) NotifyAlert(ctx context.Context, a *Alert, payloads []Payload) error {
+	return notifyWebhooks(ctx, n, payloads)
+}
+
+// webhook returns the webhook of channel, empty if it isn't sent to teams
+func (n *TeamsNotifier) webhook(channel string) string {
+	if webhook, found := n.Channels[strings.TrimLeft(channel, "#")]; found {
+		return webhook
+	}
+	return n.Webhook
+}
+
+func (n *TeamsNotifier) Notify(ctx context.Context, p *Payload) error {
+	webhook := n.webhook(p.Channel)
+	if webhook == "" {
+		slog.Debug("no teams webhook, alert is not sent to teams", "channel", p.Channel)
+		return nil
+	}
+	data, err := json.Marshal(toMessageCard(p))
+	if err != nil {
+		slog.Error("failed to marshal payload, alert would not sent", "payload", p, "err", err)
+		return err
+	}
+	return n.send(ctx, webhook, p.Channel, data)
+}
+
+// toMessageCard converts a slack payload to a MessageCard, built from its first attachment
+func toMessageCard(p *Payload) *MessageCard {
+	text := plainText(p)
+	card := &MessageCard{
+		Type:    "MessageCard",
+		Context: "http://schema.org/extensions",
+		Summary: text,
+	}
+	if len(p.Attachments) > 0 {
+		a := p.Attachments[0]
+		card.Title = a.Title
+		card.Summary = a.Title
+		card.Text = a.Text
+		if a.TitleLink != "" {
+			card.Title = "[" + a.Title + "](" + a.TitleLink + ")"
+		}
+		card.ThemeColor = strings.TrimPrefix(a.Color, "#")
+		if color, found := teamsColors[a.Color]; found {
+			card.ThemeColor = color
+		}
+		var facts []Fact
+		for _, f := range a.Fields {
+			facts = append(facts, Fact{Name: f.Title, Value: f.Value})
+		}
+		for _, action := range a.Actions {
+			if action.URL == "" {
+				continue // interactive slack buttons
+			}
+			card.Actions = append(card.Actions, OpenURI{Type: "OpenUri", Name: action.Text, Targets: []Target{{OS: "default", URI: action.URL}}})
+		}
+		if len(facts) > 0 || a.Footer != "" {
+			card.Sections = append(card.Sections, Section{Text: a.Footer, Facts: facts})
+		}
+	}
+	if text != "" && len(p.Attachments) > 0 {
+		card.Sections = append(card.Sections, Section{Text: text}) // the silence command
+	}
+	// more alerts grouped by group_by_channel get a section each
+	for i, a := range p.Attachments {
+		if i == 0 {
+			continue
+		}
+		var facts []Fact
+		for _, f := range a.Fields {
+			facts = append(facts, Fact{Name: f.Title, Value: f.Value})
+		}
+		card.Sections = append(card.Sections, Section{ActivityTitle: a.Title, Text: a.Text, Facts: facts})
+	}
+	return card
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookServer counts the posts to each path
+func webhookServer(t *testing.T) (*httptest.Server, map[string]int) {
+	var mu sync.Mutex
+	posts := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		posts[r.URL.Path]++
+		mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	return server, posts
+}
+
+func TestTeamsPostsEachWebhookOnce(t *testing.T) {
+	server, posts := webhookServer(t)
+	n := &TeamsNotifier{
+		webhookClient: webhookClient{Client: server.Client()},
+		Webhook:       server.URL + "/default",
+		Channels:      map[string]string{"ops": server.URL + "/ops", "infra": server.URL + "/ops"},
+	}
+	a := testAlert("ServiceDown")
+	var payloads []Payload
+	for _, channel := range []string{"#ops", "#infra", "#dev", "@oncall"} {
+		payloads = append(payloads, Payload{Channel: channel, Attachments: []Attachment{{Title: "ServiceDown"}}, Alerts: []*Alert{&a}})
+	}
+	if err := n.NotifyAlert(context.Background(), &a, payloads); err != nil {
+		t.Fatal(err)
+	}
+	if posts["/ops"] != 1 || posts["/default"] != 1 || len(posts) != 2 {
+		t.Errorf("posts = %v, want one to /ops and one to /default", posts)
+	}
+}
+
+func TestTeamsSilenceCommandUnescaped(t *testing.T) {
+	srv, _ := newTestServer(t, "-external_url=http://molert:8080/a&b", "-silence_duration=3600")
+	a := testAlert("ServiceDown")
+	a.GeneratorURL = "http://prometheus:9090/graph?g0.expr=up<1&g0.tab=1"
+	p := srv.toPayloads(&a, time.Time{})[0]
+	s, _ := json.Marshal(Silence{URL: a.GeneratorURL, Duration: 3600})
+	want := "`curl -XPOST 'http://molert:8080/a&b/silence' -H 'Content-Type: application/json' -d " + shellQuote(string(s)) + "`"
+	card := toMessageCard(&p)
+	if got := card.Sections[len(card.Sections)-1].Text; got != want {
+		t.Errorf("silence command = %s, want %s", got, want)
+	}
+	if !strings.Contains(p.Text, "&amp;") {
+		t.Errorf("slack text %s is not escaped", p.Text)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"text/template"
+)
+
+// defaultTemplate renders the title, text and footer of the slack attachment of an alert,
+// a -template file may redefine any of them
+const defaultTemplate = `
+{{- define "title" }}{{ with .Annotations.summary }}{{ . }}{{ else }}{{ with .Labels.alertname }}{{ . }}{{ else }}Alert{{ end }}{{ end }}{{ end -}}
+{{- define "text" }}{{ with .Annotations.description }}{{ . }}{{ else }}{{ .Annotations.message }}{{ end }}{{ end -}}
+{{- define "footer" }}{{ .Labels.env }}{{ end -}}
+`
+
+// parseTemplate parses the default template, overridden by the definitions of file if any,
+// and the footer by footer if not empty
+func parseTemplate(file, footer string) (*template.Template, error) {
+	t, err := template.New("default").Option("missingkey=zero").Parse(defaultTemplate)
+	if err != nil {
+		return nil, err
+	}
+	if file != "" {
+		if t, err = t.ParseFiles(file); err != nil {
+			return nil, fmt.Errorf("failed to parse template %s: %s", file, err)
+		}
+	}
+	if footer != "" {
+		if _, err = t.New("footer").Parse(footer); err != nil {
+			return nil, fmt.Errorf("failed to parse footer template %q: %s", footer, err)
+		}
+	}
+	return t, nil
+}
+
+// render executes the named template with the alert, whose annotations are normalized
+// as configured by normalize_annotations
+func (srv *Server) render(name string, a *Alert) string {
+	view := *a
+	view.Annotations = make(map[string]string, len(a.Annotations))
+	for k := range a.Annotations {
+		view.Annotations[k], _ = srv.annotation(a, k)
+	}
+	var buf bytes.Buffer
+	if err := srv.config().template.ExecuteTemplate(&buf, name, &view); err != nil {
+		slog.Warn("failed to render template", "template", name, "url", a.GeneratorURL, "err", err)
+	}
+	return buf.String()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+)
+
+var uiTemplate = template.Must(template.New("ui").Funcs(template.FuncMap{
+	"labels": func(labels map[string]string) []string {
+		var pairs []string
+		for name, value := range labels {
+			pairs = append(pairs, name+"="+value)
+		}
+		sort.Strings(pairs)
+		return pairs
+	},
+	"since": func(t time.Time) string {
+		return humanizeDuration(time.Since(t))
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>molert</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ddd; padding: .4em; text-align: left; vertical-align: top; }
+.label { display: inline-block; background: #eee; border-radius: 3px; padding: 0 .3em; margin: 0 .2em .2em 0; font-size: .9em; }
+.critical { color: #d32f2f; }
+.warning { color: #ffa000; }
+</style>
+</head>
+<body>
+<h1>molert</h1>
+{{ if .Maintenance }}<p><strong>Maintenance mode, no alert is sent.</strong></p>{{ end }}
+<p>{{ len .Alerts }} active alerts</p>
+<table>
+<tr><th>Alert</th><th>Labels</th><th>Started</th><th>Silence</th></tr>
+{{ range .Alerts }}
+<tr>
+<td class="{{ .Alert.Labels.severity }}"><a href="{{ .Alert.GeneratorURL }}">{{ with .Alert.Annotations.summary }}{{ . }}{{ else }}{{ .Alert.Labels.alertname }}{{ end }}</a></td>
+<td>{{ range labels .Alert.Labels }}<span class="label">{{ . }}</span>{{ end }}</td>
+<td>{{ if not .Alert.StartsAt.IsZero }}{{ since .Alert.StartsAt }} ago{{ end }}</td>
+<td>{{ if eq .TTL 0 }}<button data-url="{{ .Alert.GeneratorURL }}" onclick="silence(this)">Silence</button>
+{{ else if lt .TTL 0 }}silenced forever{{ else }}silenced for {{ .TTL }}s{{ end }}{{ with .SilencedBy }} by {{ . }}{{ end }}</td>
+</tr>
+{{ end }}
+</table>
+<script>
+function silence(button) {
+	var duration = prompt("Silence for how many seconds? Empty for the default, negative for ever.", "");
+	if (duration === null) {
+		return;
+	}
+	var body = {url: button.dataset.url, duration: parseInt(duration, 10) || 0};
+	fetch("silence", {method: "POST", headers: {"Content-Type": "application/json"}, body: JSON.stringify(body)})
+		.then(function(resp) { return resp.json(); })
+		.then(function(result) {
+			if (result.error) {
+				alert("Failed to silence: " + result.error);
+				return;
+			}
+			location.reload();
+		});
+}
+</script>
+</body>
+</html>
+`))
+
+// uiHandler renders the active alerts as an html page with a silence button per alert
+func (srv *Server) uiHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	as, err := srv.getAlerts()
+	if err != nil {
+		slog.Error("failed to list alerts", "err", err)
+		http.Error(w, err.Error(), redisErrorStatus(err))
+		return
+	}
+	if url := r.URL.Query().Get("url"); url != "" { // linked from the slack footer
+		filtered := as[:0]
+		for _, a := range as {
+			if a.Alert.GeneratorURL == url {
+				filtered = append(filtered, a)
+			}
+		}
+		as = filtered
+	}
+	less, _ := alertOrder("", srv.config())
+	sort.SliceStable(as, func(i, j int) bool { return less(as[i], as[j]) })
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = uiTemplate.Execute(w, struct {
+		Alerts      []*AlertStatus
+		Maintenance bool
+	}{as, srv.inMaintenance()})
+	if err != nil {
+		slog.Warn("failed to render ui", "err", err)
+	}
+}